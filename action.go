@@ -0,0 +1,55 @@
+package duplikaatti
+
+import (
+	"fmt"
+	"os"
+)
+
+// Action is done to each duplicate file which is not kept
+type Action interface {
+	Apply(keep File, duplicate File) error
+}
+
+// RemoveAction removes duplicates. Duplicate is only removed when the kept
+// file still exists and neither file has changed since they were compared.
+type RemoveAction struct{}
+
+func (RemoveAction) Apply(keep File, duplicate File) error {
+	if keep.IsArchiveMember() || duplicate.IsArchiveMember() {
+		return fmt.Errorf(`%v: archive members are never removed and are not kept instead of files`, duplicate.Path)
+	}
+
+	err := checkUnchanged(keep)
+	if err != nil {
+		return fmt.Errorf(`kept file: %w`, err)
+	}
+
+	err = checkUnchanged(duplicate)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(duplicate.Path)
+}
+
+// Check that file still has the size and modification time it had when it
+// was compared
+func checkUnchanged(f File) error {
+	fi, err := os.Lstat(f.Path)
+	if err != nil {
+		return err
+	}
+
+	if uint64(fi.Size()) != f.Size || (!f.ModTime.IsZero() && !fi.ModTime().Equal(f.ModTime)) {
+		return &os.PathError{Op: `stat`, Path: f.Path, Err: ErrChanged}
+	}
+
+	return nil
+}
+
+// DryRunAction doesn't touch the files
+type DryRunAction struct{}
+
+func (DryRunAction) Apply(keep File, duplicate File) error {
+	return nil
+}
@@ -0,0 +1,621 @@
+package duplikaatti
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Separator between archive path and member path in File.Path of archive members
+const ARCHIVE_SEPARATOR = `!/`
+
+// Archive formats
+const (
+	archiveNone = iota
+	archiveZip
+	archiveTar
+	archiveTarGzip
+	archiveTarZstd
+)
+
+// Detect archive format from file name
+func archiveFormat(path string) int {
+	name := strings.ToLower(path)
+
+	switch {
+	case strings.HasSuffix(name, `.zip`):
+		return archiveZip
+	case strings.HasSuffix(name, `.tar`):
+		return archiveTar
+	case strings.HasSuffix(name, `.tar.gz`), strings.HasSuffix(name, `.tgz`):
+		return archiveTarGzip
+	case strings.HasSuffix(name, `.tar.zst`), strings.HasSuffix(name, `.tzst`):
+		return archiveTarZstd
+	}
+
+	return archiveNone
+}
+
+// Member of an archive
+type archiveMember struct {
+	Name    string
+	Size    uint64
+	ModTime time.Time
+}
+
+// List regular files of an archive
+func listArchive(path string) (members []archiveMember, err error) {
+	switch archiveFormat(path) {
+	case archiveZip:
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+
+			members = append(members, archiveMember{
+				Name:    f.Name,
+				Size:    f.UncompressedSize64,
+				ModTime: f.Modified,
+			})
+		}
+
+		return members, nil
+
+	case archiveTar, archiveTarGzip, archiveTarZstd:
+		tr, closer, err := openTar(path)
+		if err != nil {
+			return nil, err
+		}
+		defer closer.Close()
+
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return members, err
+			}
+
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+
+			members = append(members, archiveMember{
+				Name:    hdr.Name,
+				Size:    uint64(hdr.Size),
+				ModTime: hdr.ModTime,
+			})
+		}
+
+		return members, nil
+	}
+
+	return nil, fmt.Errorf(`not a supported archive: %v`, path)
+}
+
+// Open tar archive, decompressed if the name says so
+func openTar(path string) (tr *tar.Reader, closer io.Closer, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch archiveFormat(path) {
+	case archiveTarGzip:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+
+		return tar.NewReader(gz), f, nil
+
+	case archiveTarZstd:
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+
+		return tar.NewReader(zr), closers{zr.IOReadCloser(), f}, nil
+	}
+
+	return tar.NewReader(f), f, nil
+}
+
+// Closes all closers and returns the first error
+type closers []io.Closer
+
+func (c closers) Close() (err error) {
+	for _, cl := range c {
+		cerr := cl.Close()
+		if err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Archives kept open at most. Least recently used archives which aren't being
+// read are closed and their decompressed members removed.
+const ARCHIVE_MAX_OPEN = 32
+
+// archiveCache keeps recently used archives open while their members are
+// read, so an archive isn't opened and parsed for every member. Zip members are
+// read directly from the archive and members of plain tar archives from their
+// offset in the archive. Members of compressed tar archives are decompressed to
+// a temporary file in given directory, only members marked with want and the
+// requested member are decompressed. It's safe for concurrent use and Close
+// removes the temporary files.
+type archiveCache struct {
+	mu       sync.Mutex
+	tempDir  string // Empty for the default temporary directory
+	archives map[string]*cachedArchive
+	wanted   map[string]map[string]struct{} // Members which will be read by archive path
+	clock    uint64
+}
+
+// Opened archive
+type cachedArchive struct {
+	mu      sync.Mutex // Held while loading and decompressing
+	loaded  bool
+	err     error
+	refs    int    // Open member readers, guarded by archiveCache.mu
+	used    uint64 // Last use for eviction, guarded by archiveCache.mu
+	zip     *zip.ReadCloser
+	zipped  map[string]*zip.File
+	members map[string]tarMember
+	files   closers  // Archive or temporary file
+	temp    *os.File // Decompressed members of compressed tar, nil until needed
+}
+
+// Location of a tar member
+type tarMember struct {
+	archiveMember
+	r      io.ReaderAt
+	offset int64
+}
+
+func newArchiveCache(tempDir string) *archiveCache {
+	return &archiveCache{
+		tempDir:  tempDir,
+		archives: map[string]*cachedArchive{},
+		wanted:   map[string]map[string]struct{}{},
+	}
+}
+
+// want marks member of an archive to be decompressed when the archive is
+// decompressed for any member, so the archive is read only once
+func (c *archiveCache) want(path string, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.wanted[path] == nil {
+		c.wanted[path] = map[string]struct{}{}
+	}
+
+	c.wanted[path][name] = struct{}{}
+}
+
+// Close archives and remove temporary files
+func (c *archiveCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, a := range c.archives {
+		a.close()
+		delete(c.archives, path)
+	}
+}
+
+// Close archive and remove its temporary file
+func (a *cachedArchive) close() {
+	a.files.Close()
+
+	if a.temp != nil {
+		os.Remove(a.temp.Name())
+	}
+}
+
+// Close least recently used archives which aren't being read until at most
+// ARCHIVE_MAX_OPEN archives are open. Must be called with lock held.
+func (c *archiveCache) evict() {
+	for len(c.archives) > ARCHIVE_MAX_OPEN {
+		var lruPath string
+		var lru *cachedArchive
+
+		for path, a := range c.archives {
+			if a.refs == 0 && (lru == nil || a.used < lru.used) {
+				lruPath, lru = path, a
+			}
+		}
+
+		if lru == nil {
+			// All are being read, closed once released
+			return
+		}
+
+		lru.close()
+		delete(c.archives, lruPath)
+	}
+}
+
+// Archive stops being used by a reader
+func (c *archiveCache) release(a *cachedArchive) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	a.refs--
+	c.evict()
+}
+
+// open returns reader of a member of an archive
+func (c *archiveCache) open(path string, name string) (r io.ReadCloser, m archiveMember, err error) {
+	c.mu.Lock()
+	a, ok := c.archives[path]
+	if !ok {
+		a = &cachedArchive{}
+		c.archives[path] = a
+	}
+
+	c.clock++
+	a.used = c.clock
+	a.refs++
+
+	c.evict()
+	c.mu.Unlock()
+
+	a.mu.Lock()
+	r, m, err = c.member(a, path, name)
+	a.mu.Unlock()
+
+	if err != nil {
+		c.release(a)
+		return nil, m, err
+	}
+
+	mr := &memberReader{ReadCloser: r, release: func() {
+		c.release(a)
+	}}
+
+	if s, ok := r.(io.Seeker); ok {
+		return seekingMemberReader{mr, s}, m, nil
+	}
+
+	return mr, m, nil
+}
+
+// Reader of a member, must be called with archive lock held
+func (c *archiveCache) member(a *cachedArchive, path string, name string) (r io.ReadCloser, m archiveMember, err error) {
+	if !a.loaded {
+		a.err = c.load(a, path)
+		a.loaded = true
+	}
+
+	if a.err != nil {
+		return nil, m, a.err
+	}
+
+	if a.zip != nil {
+		if f, ok := a.zipped[name]; ok {
+			fr, err := f.Open()
+			if err != nil {
+				return nil, m, err
+			}
+
+			m = archiveMember{
+				Name:    f.Name,
+				Size:    f.UncompressedSize64,
+				ModTime: f.Modified,
+			}
+
+			return fr, m, nil
+		}
+	}
+
+	tm, ok := a.members[name]
+	if !ok && a.zip == nil {
+		err = c.decompress(a, path, name)
+		if err != nil {
+			a.err = err
+			return nil, m, err
+		}
+
+		tm, ok = a.members[name]
+	}
+
+	if ok {
+		return sectionReader{io.NewSectionReader(tm.r, tm.offset, int64(tm.Size))}, tm.archiveMember, nil
+	}
+
+	return nil, m, &os.PathError{Op: `open`, Path: path + ARCHIVE_SEPARATOR + name, Err: os.ErrNotExist}
+}
+
+// Open and index archive. Compressed tar archives are decompressed when a
+// member is read. The first member with a name is used like when the archive
+// is listed.
+func (c *archiveCache) load(a *cachedArchive, path string) (err error) {
+	a.members = map[string]tarMember{}
+
+	switch archiveFormat(path) {
+	case archiveZip:
+		a.zip, err = zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+
+		a.files = closers{a.zip}
+		a.zipped = map[string]*zip.File{}
+
+		for _, f := range a.zip.File {
+			if _, ok := a.zipped[f.Name]; !ok && f.Mode().IsRegular() {
+				a.zipped[f.Name] = f
+			}
+		}
+
+		return nil
+
+	case archiveTar:
+	default:
+		return nil
+	}
+
+	tr, closer, err := openTar(path)
+	if err != nil {
+		return err
+	}
+
+	a.files = closers{closer}
+
+	// Plain tar archive is read at member offsets
+	f := closer.(*os.File)
+	seen := map[string]bool{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if seen[hdr.Name] || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		seen[hdr.Name] = true
+
+		if isSparse(hdr) {
+			// Read from decompressed copy like members of compressed archives
+			continue
+		}
+
+		// Reader is at the beginning of member data after the header
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		a.members[hdr.Name] = tarMember{
+			archiveMember: archiveMember{
+				Name:    hdr.Name,
+				Size:    uint64(hdr.Size),
+				ModTime: hdr.ModTime,
+			},
+			r:      f,
+			offset: offset,
+		}
+	}
+
+	return nil
+}
+
+// Decompress given member and wanted members which aren't decompressed yet to
+// the temporary file of the archive. Must be called with archive lock held.
+func (c *archiveCache) decompress(a *cachedArchive, path string, name string) (err error) {
+	c.mu.Lock()
+	wanted := map[string]struct{}{name: {}}
+	for w := range c.wanted[path] {
+		if _, ok := a.members[w]; !ok {
+			wanted[w] = struct{}{}
+		}
+	}
+	c.mu.Unlock()
+
+	tr, closer, err := openTar(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	seen := map[string]bool{}
+
+	// Stop when all wanted members are decompressed
+	for len(wanted) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if seen[hdr.Name] || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		seen[hdr.Name] = true
+
+		if _, ok := wanted[hdr.Name]; !ok {
+			continue
+		}
+
+		delete(wanted, hdr.Name)
+
+		if a.temp == nil {
+			a.temp, err = ioutil.TempFile(c.tempDir, `duplikaatti-archive-`)
+			if err != nil {
+				return err
+			}
+
+			a.files = append(a.files, a.temp)
+		}
+
+		m := tarMember{
+			archiveMember: archiveMember{
+				Name:    hdr.Name,
+				Size:    uint64(hdr.Size),
+				ModTime: hdr.ModTime,
+			},
+			r: a.temp,
+		}
+
+		m.offset, err = a.temp.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(a.temp, tr)
+		if err != nil {
+			return err
+		}
+
+		a.members[hdr.Name] = m
+	}
+
+	return nil
+}
+
+// Member of a tar archive, the archive is closed by archiveCache
+type sectionReader struct {
+	*io.SectionReader
+}
+
+func (sectionReader) Close() error {
+	return nil
+}
+
+// Member reader which releases the archive when closed
+type memberReader struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *memberReader) Close() (err error) {
+	err = r.ReadCloser.Close()
+	r.once.Do(r.release)
+
+	return err
+}
+
+// Member reader which can seek
+type seekingMemberReader struct {
+	*memberReader
+	io.Seeker
+}
+
+// Sparse members aren't stored as is in the archive
+func isSparse(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, `GNU.sparse.`) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// File or archive member opened for reading
+type openedFile struct {
+	io.ReadCloser
+	Path    string
+	ModTime time.Time
+	Owner   uint32 // Owner of the archive for archive members
+}
+
+// openEntry opens file or archive member and checks that its size is still
+// the same as when it was found
+func openEntry(paths *pathTable, archives *archiveCache, e fileEntry) (o openedFile, err error) {
+	path := paths.join(e.Dir, e.Name)
+
+	if e.Member == `` {
+		f, err := os.Open(path)
+		if err != nil {
+			return o, err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return o, err
+		}
+
+		o.ReadCloser = f
+		o.Path = path
+		o.ModTime = fi.ModTime()
+		o.Owner = getOwner(fi)
+
+		if uint64(fi.Size()) != e.Size {
+			f.Close()
+			return o, &os.PathError{Op: `read`, Path: path, Err: ErrChanged}
+		}
+
+		return o, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return o, err
+	}
+
+	r, m, err := archives.open(path, e.Member)
+	if err != nil {
+		return o, err
+	}
+
+	o.ReadCloser = r
+	o.Owner = getOwner(fi)
+	o.Path = path + ARCHIVE_SEPARATOR + e.Member
+	o.ModTime = m.ModTime
+
+	if m.Size != e.Size {
+		r.Close()
+		return o, &os.PathError{Op: `read`, Path: o.Path, Err: ErrChanged}
+	}
+
+	return o, nil
+}
+
+// Skip given amount of bytes. Compressed zip members can't seek, so they're read.
+func (o openedFile) skip(n int64) (err error) {
+	if s, ok := o.ReadCloser.(io.Seeker); ok {
+		_, err = s.Seek(n, io.SeekCurrent)
+		return err
+	}
+
+	_, err = io.CopyN(ioutil.Discard, o.ReadCloser, n)
+
+	return err
+}
@@ -0,0 +1,268 @@
+package duplikaatti
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Write members to a tar stream
+func writeTar(t *testing.T, w io.Writer, members map[string]string, names []string) {
+	t.Helper()
+
+	tw := tar.NewWriter(w)
+
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(members[name])),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tw.Write([]byte(members[name]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveCache(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{`a`, `dir/b`, `c`, `long/name-which-is-longer-than-one-hundred-characters-so-that-a-pax-header-is-needed-before-the-real-header`}
+	members := map[string]string{
+		names[0]: `first member`,
+		names[1]: `second member which is longer than the first one`,
+		names[2]: `third`,
+		names[3]: `member after an extended header`,
+	}
+
+	create := func(name string, write func(w io.Writer)) string {
+		path := filepath.Join(dir, name)
+
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		write(f)
+
+		err = f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	archives := []string{
+		create(`plain.tar`, func(w io.Writer) {
+			writeTar(t, w, members, names)
+		}),
+		create(`gzip.tar.gz`, func(w io.Writer) {
+			gz := gzip.NewWriter(w)
+			writeTar(t, gz, members, names)
+			gz.Close()
+		}),
+		create(`zstd.tar.zst`, func(w io.Writer) {
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			writeTar(t, zw, members, names)
+			zw.Close()
+		}),
+		create(`zip.zip`, func(w io.Writer) {
+			zw := zip.NewWriter(w)
+
+			for _, name := range names {
+				fw, err := zw.Create(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				fw.Write([]byte(members[name]))
+			}
+
+			zw.Close()
+		}),
+	}
+
+	cache := newArchiveCache(dir)
+
+	for _, path := range archives {
+		listed, err := listArchive(path)
+		if err != nil {
+			t.Fatalf(`%v: %v`, path, err)
+		}
+
+		if len(listed) != len(names) {
+			t.Fatalf(`%v: got %v members, expected %v`, path, len(listed), len(names))
+		}
+
+		// Read in reverse order, and twice, so that members aren't read in archive order
+		for i := 0; i < 2; i++ {
+			for idx := len(names) - 1; idx >= 0; idx-- {
+				name := names[idx]
+
+				r, m, err := cache.open(path, name)
+				if err != nil {
+					t.Fatalf(`%v: %v: %v`, path, name, err)
+				}
+
+				data, err := ioutil.ReadAll(r)
+				r.Close()
+
+				if err != nil {
+					t.Fatalf(`%v: %v: %v`, path, name, err)
+				}
+
+				if string(data) != members[name] || m.Size != uint64(len(members[name])) {
+					t.Fatalf(`%v: %v: got %#v (size %v), expected %#v`, path, name, string(data), m.Size, members[name])
+				}
+			}
+		}
+
+		_, _, err = cache.open(path, `missing`)
+		if !os.IsNotExist(err) {
+			t.Fatalf(`%v: got error %v for missing member`, path, err)
+		}
+	}
+
+	cache.Close()
+
+	// Decompressed members are removed
+	temps, err := filepath.Glob(filepath.Join(dir, `duplikaatti-archive-*`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(temps) != 0 {
+		t.Fatalf(`temporary files left: %v`, temps)
+	}
+}
+
+func TestArchiveCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{`wanted`, `other`}
+	members := map[string]string{
+		`wanted`: `member which is read`,
+		`other`:  `member which is never decompressed`,
+	}
+
+	cache := newArchiveCache(dir)
+	defer cache.Close()
+
+	var archives []string
+
+	for i := 0; i < ARCHIVE_MAX_OPEN+8; i++ {
+		path := filepath.Join(dir, fmt.Sprintf(`%03d.tar.gz`, i))
+
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gz := gzip.NewWriter(f)
+		writeTar(t, gz, members, names)
+		gz.Close()
+		f.Close()
+
+		cache.want(path, `wanted`)
+		archives = append(archives, path)
+	}
+
+	read := func(path string) io.ReadCloser {
+		r, _, err := cache.open(path, `wanted`)
+		if err != nil {
+			t.Fatalf(`%v: %v`, path, err)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		if err != nil || string(data) != members[`wanted`] {
+			t.Fatalf(`%v: got %#v, %v`, path, string(data), err)
+		}
+
+		return r
+	}
+
+	// Only the wanted member is decompressed and archives are evicted
+	for _, path := range archives {
+		read(path).Close()
+
+		temps, err := filepath.Glob(filepath.Join(dir, `duplikaatti-archive-*`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(temps) > ARCHIVE_MAX_OPEN {
+			t.Fatalf(`%v temporary files, expected at most %v`, len(temps), ARCHIVE_MAX_OPEN)
+		}
+
+		for _, temp := range temps {
+			fi, err := os.Stat(temp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fi.Size() != int64(len(members[`wanted`])) {
+				t.Fatalf(`%v has %v bytes, expected only the wanted member`, temp, fi.Size())
+			}
+		}
+	}
+
+	if len(cache.archives) > ARCHIVE_MAX_OPEN {
+		t.Fatalf(`%v archives open, expected at most %v`, len(cache.archives), ARCHIVE_MAX_OPEN)
+	}
+
+	// Archives being read aren't evicted
+	var open []io.ReadCloser
+	for _, path := range archives {
+		r, _, err := cache.open(path, `wanted`)
+		if err != nil {
+			t.Fatalf(`%v: %v`, path, err)
+		}
+
+		open = append(open, r)
+	}
+
+	for idx, r := range open {
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+
+		if err != nil || string(data) != members[`wanted`] {
+			t.Fatalf(`%v: got %#v, %v`, archives[idx], string(data), err)
+		}
+	}
+
+	if len(cache.archives) > ARCHIVE_MAX_OPEN {
+		t.Fatalf(`%v archives open after readers were closed, expected at most %v`, len(cache.archives), ARCHIVE_MAX_OPEN)
+	}
+}
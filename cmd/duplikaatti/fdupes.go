@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// Size header written by fdupes -S and jdupes -S
+var fdupesSizeHeader = regexp.MustCompile(`^\d+ bytes? each:$`)
+
+// Write duplicate groups like fdupes and jdupes do: paths of a group one per
+// line and groups separated with an empty line. Kept file is the first path
+// and the rest are the files which would be removed, so reference files and
+// archive members are left out as tools reading the list would delete them.
+// With sameLine each group is one line with spaces and backslashes in paths
+// escaped with a backslash and without empty lines between groups. With sizes
+// each group starts with a size header.
+func writeFdupes(w io.Writer, groups []duplikaatti.DuplicateGroup, sameLine bool, sizes bool) (err error) {
+	bw := bufio.NewWriter(w)
+
+	for _, g := range groups {
+		dupes := g.Duplicates()
+		if len(dupes) == 0 {
+			continue
+		}
+
+		if sizes {
+			unit := `bytes`
+			if g.Keep().Size == 1 {
+				unit = `byte`
+			}
+
+			fmt.Fprintf(bw, "%v %v each:\n", g.Keep().Size, unit)
+		}
+
+		for idx, f := range append([]duplikaatti.File{g.Keep()}, dupes...) {
+			switch {
+			case !sameLine:
+				fmt.Fprintf(bw, "%v\n", f.Path)
+			case idx == 0:
+				fmt.Fprintf(bw, "%v", fdupesEscape(f.Path))
+			default:
+				fmt.Fprintf(bw, " %v", fdupesEscape(f.Path))
+			}
+		}
+
+		// Ends the line of the group, or the group with an empty line
+		fmt.Fprintf(bw, "\n")
+	}
+
+	return bw.Flush()
+}
+
+// Write duplicate groups to a file, '-' is stdout
+func writeFdupesFile(path string, groups []duplikaatti.DuplicateGroup, sameLine bool, sizes bool) (err error) {
+	if path == `-` {
+		return writeFdupes(os.Stdout, groups, sameLine, sizes)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = writeFdupes(f, groups, sameLine, sizes)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func fdupesEscape(path string) string {
+	return strings.NewReplacer(`\`, `\\`, ` `, `\ `).Replace(path)
+}
+
+// Split a line written with fdupes -1 to paths
+func fdupesSplitLine(line string) (paths []string) {
+	var b strings.Builder
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ' ':
+			if b.Len() > 0 {
+				paths = append(paths, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	if b.Len() > 0 {
+		paths = append(paths, b.String())
+	}
+
+	return paths
+}
+
+// fdupesPlan is a list of duplicates written by fdupes, jdupes or
+// duplikaatti. The first file of each group is kept and the rest are removed.
+// A file which is kept in some group is never removed.
+type fdupesPlan struct {
+	paths []string          // All listed files in listed order
+	keep  map[string]string // Kept file of each listed file which is removed
+	kept  map[string]bool   // Files which are kept
+}
+
+// loadFdupesPlan reads groups from a file ('-' is stdin). Groups are separated
+// with empty lines and have one path per line, or all paths on one line like
+// written with -1. With sameLine each line is a group like written with -1
+// without empty lines between groups. Size headers are skipped.
+func loadFdupesPlan(path string, sameLine bool) (plan fdupesPlan, err error) {
+	var r io.Reader = os.Stdin
+
+	if path != `-` {
+		f, err := os.Open(path)
+		if err != nil {
+			return plan, err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	plan.keep = map[string]string{}
+	plan.kept = map[string]bool{}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 65536), 16*1048576)
+
+	var lines []string
+
+	addGroup := func() {
+		group := lines
+		lines = nil
+
+		if len(group) == 1 {
+			group = fdupesSplitLine(group[0])
+		}
+
+		for idx, p := range group {
+			plan.paths = append(plan.paths, p)
+
+			if idx == 0 {
+				plan.kept[p] = true
+			} else {
+				plan.keep[p] = group[0]
+			}
+		}
+	}
+
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+
+		switch {
+		case line == ``:
+			addGroup()
+		case fdupesSizeHeader.MatchString(line):
+			addGroup()
+		case sameLine:
+			addGroup()
+			lines = append(lines, line)
+			addGroup()
+		default:
+			lines = append(lines, line)
+		}
+	}
+
+	if err = sc.Err(); err != nil {
+		return plan, err
+	}
+
+	addGroup()
+
+	return plan, nil
+}
+
+// Apply plan to groups found from the listed files. A file is only removed
+// when it's identical to the file which the plan keeps, so groups are
+// narrowed down to the kept file and its planned duplicates.
+func (p fdupesPlan) Apply(groups []duplikaatti.DuplicateGroup) (planned []duplikaatti.DuplicateGroup) {
+	for _, g := range groups {
+		inGroup := map[string]int{}
+		for idx, f := range g.Files {
+			inGroup[f.Path] = idx
+		}
+
+		// Duplicates of each kept file of the plan
+		byKeep := map[string][]duplikaatti.File{}
+		var keeps []string
+
+		for _, f := range g.Files {
+			keep, ok := p.keep[f.Path]
+			if !ok || p.kept[f.Path] {
+				continue
+			}
+
+			if _, ok := inGroup[keep]; !ok {
+				continue
+			}
+
+			if _, ok := byKeep[keep]; !ok {
+				keeps = append(keeps, keep)
+			}
+
+			byKeep[keep] = append(byKeep[keep], f)
+		}
+
+		for _, keep := range keeps {
+			files := append([]duplikaatti.File{g.Files[inGroup[keep]]}, byKeep[keep]...)
+
+			planned = append(planned, duplikaatti.DuplicateGroup{
+				Hash:     g.Hash,
+				Files:    files,
+				KeepRule: `fdupes plan`,
+			})
+		}
+	}
+
+	return planned
+}
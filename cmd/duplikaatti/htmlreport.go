@@ -0,0 +1,189 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// Group of duplicates shown in HTML report
+type htmlGroup struct {
+	Hash       string
+	Size       uint64
+	Wasted     uint64 // Size of files which would be removed
+	Files      int
+	Keep       duplikaatti.File
+	KeepRule   string
+	Duplicates []htmlFile
+}
+
+// File of a group which isn't kept
+type htmlFile struct {
+	duplikaatti.File
+	Action string // remove, reference or archive member
+}
+
+type htmlReport struct {
+	Generated time.Time
+	Summary   duplikaatti.SpaceSummary
+	Groups    []htmlGroup
+}
+
+// Write duplicate groups to a self-contained HTML file, largest wasted space first
+func writeHTMLReport(path string, groups []duplikaatti.DuplicateGroup, roots []string) (err error) {
+	report := htmlReport{
+		Generated: time.Now(),
+		Summary:   duplikaatti.SummarizeSpace(groups, roots),
+	}
+
+	for _, g := range groups {
+		hg := htmlGroup{
+			Hash:     g.Hash,
+			Size:     g.Keep().Size,
+			Files:    len(g.Files),
+			Keep:     g.Keep(),
+			KeepRule: g.KeepRule,
+		}
+
+		for _, f := range g.Files[1:] {
+			action := `remove`
+
+			switch {
+			case f.Reference:
+				action = `reference`
+			case f.Outside:
+				action = `outside roots`
+			case f.IsArchiveMember():
+				action = `archive member`
+			default:
+				hg.Wasted += f.Size
+			}
+
+			hg.Duplicates = append(hg.Duplicates, htmlFile{File: f, Action: action})
+		}
+
+		report.Groups = append(report.Groups, hg)
+	}
+
+	sort.SliceStable(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Wasted > report.Groups[j].Wasted
+	})
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = htmlReportTemplate.Execute(f, report)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+var htmlReportTemplate = template.Must(template.New(`report`).Funcs(template.FuncMap{
+	`human`: duplikaatti.BytesToHuman,
+	`time`: func(t time.Time) string {
+		return t.Format(`2006-01-02 15:04:05`)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>duplikaatti report {{ time .Generated }}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th.sort { cursor: pointer; background: #eee; }
+td.num { text-align: right; white-space: nowrap; }
+.keep { color: #060; }
+.remove { color: #a00; }
+.reference, .archive { color: #666; }
+ul { margin: 0; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<h1>Duplicate files</h1>
+<p>Generated {{ time .Generated }}. {{ len .Groups }} groups, {{ .Summary.Files }} files ({{ human .Summary.Bytes }}) would be removed.</p>
+
+<h2>By scan root</h2>
+<table class="sortable">
+<thead><tr><th class="sort">Root</th><th class="sort">Files</th><th class="sort">Reclaimable</th></tr></thead>
+<tbody>
+{{- range .Summary.Roots }}
+<tr><td>{{ .Name }}</td><td class="num" data-value="{{ .Files }}">{{ .Files }}</td><td class="num" data-value="{{ .Bytes }}">{{ human .Bytes }}</td></tr>
+{{- end }}
+</tbody>
+</table>
+
+<h2>By top-level directory</h2>
+<table class="sortable">
+<thead><tr><th class="sort">Directory</th><th class="sort">Files</th><th class="sort">Reclaimable</th></tr></thead>
+<tbody>
+{{- range .Summary.Directories }}
+<tr><td>{{ .Name }}</td><td class="num" data-value="{{ .Files }}">{{ .Files }}</td><td class="num" data-value="{{ .Bytes }}">{{ human .Bytes }}</td></tr>
+{{- end }}
+</tbody>
+</table>
+
+<h2>Groups</h2>
+<table class="sortable">
+<thead><tr><th class="sort">Wasted</th><th class="sort">File size</th><th class="sort">Files</th><th>Kept file</th><th>Other files</th></tr></thead>
+<tbody>
+{{- range .Groups }}
+<tr>
+<td class="num" data-value="{{ .Wasted }}">{{ human .Wasted }}</td>
+<td class="num" data-value="{{ .Size }}">{{ human .Size }}</td>
+<td class="num" data-value="{{ .Files }}">{{ .Files }}</td>
+<td class="keep" title="{{ .Hash }}">{{ .Keep.Path }}<br><small>{{ .KeepRule }}</small></td>
+<td><ul>
+{{- range .Duplicates }}
+<li class="{{ if eq .Action "archive member" }}archive{{ else }}{{ .Action }}{{ end }}">{{ .Path }}{{ if ne .Action "remove" }} <small>({{ .Action }})</small>{{ end }}</li>
+{{- end }}
+</ul></td>
+</tr>
+{{- end }}
+</tbody>
+</table>
+
+<script>
+// Sort table by clicked column, numeric columns by their data-value
+document.querySelectorAll('table.sortable').forEach(function (table) {
+	table.querySelectorAll('th.sort').forEach(function (th, col) {
+		var desc = false;
+
+		th.addEventListener('click', function () {
+			var body = table.tBodies[0];
+			var rows = Array.prototype.slice.call(body.rows);
+
+			desc = !desc;
+
+			rows.sort(function (a, b) {
+				var x = a.cells[col], y = b.cells[col];
+				var cmp;
+
+				if (x.dataset.value !== undefined) {
+					cmp = Number(x.dataset.value) - Number(y.dataset.value);
+				} else {
+					cmp = x.textContent.localeCompare(y.textContent);
+				}
+
+				return desc ? -cmp : cmp;
+			});
+
+			rows.forEach(function (row) {
+				body.appendChild(row);
+			});
+		});
+	});
+});
+</script>
+</body>
+</html>
+`))
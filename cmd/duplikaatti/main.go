@@ -0,0 +1,637 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/raspi/duplikaatti"
+)
+
+var VERSION = `0.0.0`
+var BUILD = `dev`
+
+const (
+	AUTHOR   = `Pekka Järvinen`
+	YEAR     = 2018
+	HOMEPAGE = `https://github.com/raspi/duplikaatti`
+)
+
+func main() {
+	readSize := int64(duplikaatti.MEBIBYTE)
+
+	actuallyRemove := false
+	flag.BoolVar(&actuallyRemove, `remove`, false, `Actually remove files.`)
+
+	followSymlinks := false
+	flag.BoolVar(&followSymlinks, `follow-symlinks`, false, `Follow symbolic links to files and directories.`)
+
+	useTUI := false
+	flag.BoolVar(&useTUI, `tui`, false, `Review duplicate groups interactively in terminal before removing.`)
+
+	interactive := false
+	flag.BoolVar(&interactive, `interactive`, false, `Ask what to do with each duplicate group.`)
+
+	planPath := ``
+	flag.StringVar(&planPath, `plan`, ``, `Plan file for -interactive. Answers are saved to it and already answered groups are not asked again.`)
+
+	progressFormat := duplikaatti.PROGRESS_HUMAN
+	flag.StringVar(&progressFormat, `progress`, duplikaatti.PROGRESS_HUMAN, `Progress output: human, json (newline delimited JSON events) or none.`)
+
+	progressFd := 2
+	flag.IntVar(&progressFd, `progress-fd`, 2, `File descriptor where progress is written.`)
+
+	var refs referenceFlag
+	flag.Var(&refs, `ref`, `Read-only reference directory, files are used for matching but never removed. Can be given multiple times.`)
+
+	var prios priorityFlag
+	flag.Var(&prios, `prio`, `Directory with explicit priority as <priority>:<path> (0-255, higher is kept). Can be given multiple times.`)
+
+	var keepRules keepRuleFlag
+	flag.Var(&keepRules, `keep`, fmt.Sprintf(`Rule for selecting which file is kept, rules are applied in given order. Can be given multiple times. (default %v)`, strings.Join(duplikaatti.DefaultKeepRules, `, `)))
+
+	filesFrom := ``
+	flag.StringVar(&filesFrom, `files-from`, ``, `Read newline or NUL separated list of files from given file ('-' is stdin).`)
+
+	compare := false
+	flag.BoolVar(&compare, `compare`, false, `Compare files byte by byte instead of hashing whole files.`)
+
+	spillDir := ``
+	flag.StringVar(&spillDir, `spill-dir`, ``, `Keep files with unique size in a temporary file in given directory instead of memory after a million files.`)
+
+	findDirs := false
+	flag.BoolVar(&findDirs, `dirs`, false, `Also report directories whose whole contents are identical.`)
+
+	similarPercent := 0.0
+	flag.Float64Var(&similarPercent, `similar`, 0, `Report pairs of directories where at least given percent of bytes of either directory have a copy in the other one.`)
+
+	summaryTop := 0
+	flag.IntVar(&summaryTop, `summary`, 0, `Summarize reclaimable space by scan root, top-level directory, owner and extension, listing given amount of largest entries.`)
+
+	reportHTML := ``
+	flag.StringVar(&reportHTML, `report-html`, ``, `Write duplicate groups to given HTML file before removing anything.`)
+
+	emitScript := ``
+	flag.StringVar(&emitScript, `emit-script`, ``, `Write shell script of commands to given file instead of removing files.`)
+
+	scriptCommand := duplikaatti.SCRIPT_REMOVE
+	flag.StringVar(&scriptCommand, `script-command`, duplikaatti.SCRIPT_REMOVE, `Command used for duplicates in -emit-script: rm, ln (hard link to kept file) or reflink (cp --reflink).`)
+
+	rdfindResults := ``
+	flag.StringVar(&rdfindResults, `rdfind-results`, ``, `Write duplicate groups to given file in the results.txt format of rdfind.`)
+
+	fdupesOut := ``
+	flag.StringVar(&fdupesOut, `fdupes`, ``, `Write duplicate groups to given file ('-' is stdout) like fdupes and jdupes, kept file first.`)
+
+	fdupesSameLine := false
+	flag.BoolVar(&fdupesSameLine, `fdupes-sameline`, false, `Write each group of -fdupes on one line like fdupes -1, and read -fdupes-plan written that way.`)
+
+	fdupesSize := false
+	flag.BoolVar(&fdupesSize, `fdupes-size`, false, `Write size of files before each group of -fdupes like fdupes -S.`)
+
+	fdupesPlanPath := ``
+	flag.StringVar(&fdupesPlanPath, `fdupes-plan`, ``, `Read groups written by fdupes, jdupes or -fdupes from given file ('-' is stdin). Listed files are compared and the first file of each group is kept.`)
+
+	archives := false
+	flag.BoolVar(&archives, `archives`, false, `Also compare files inside zip, tar, tar.gz and tar.zst archives. Archive members are only reported, never removed.`)
+
+	flag.Usage = func() {
+		f := filepath.Base(os.Args[0])
+
+		fmt.Fprintf(flag.CommandLine.Output(), "Duplicate file remover (version %v build %v)\n", VERSION, BUILD)
+		fmt.Fprintf(flag.CommandLine.Output(), "Removes duplicate files. Algorithm idea from rdfind.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s [options] <directories and/or files>:\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "\nParameters:\n")
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+
+		fmt.Fprintf(flag.CommandLine.Output(), "Examples:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  Test what would be removed:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Remove files:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -remove /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Remove files from /incoming which already exist in /archive:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -remove -ref /archive /incoming\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Review what is kept and removed before removing:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -tui -remove /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Find copies of whole directories:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -dirs /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Find directories where at least 80%% of bytes have a copy in another directory:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -similar 80 /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Show where most space would be reclaimed:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -summary 10 /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Write report of a dry run to a HTML file:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -report-html report.html /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Write shell script which replaces duplicates with hard links:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -emit-script dedupe.sh -script-command ln /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Write results for tools made for rdfind:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -rdfind-results results.txt /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Print groups like fdupes -1 does:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -fdupes - -fdupes-sameline /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Remove duplicates listed by fdupes, keeping the first file of each group:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    fdupes -r /mnt/storage > dupes.txt; %v -fdupes-plan dupes.txt -remove\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Ask what to remove and save answers so that session can be continued later:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    %v -interactive -plan answers.plan -remove /home/raspi/storage /mnt/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "  Use file list generated by find:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    find /mnt/storage -name '*.jpg' -print0 | %v -files-from - /home/raspi/storage\n", f)
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+
+		ai := 1
+		fmt.Fprintf(flag.CommandLine.Output(), "Algorithm:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Get file list from given directories and files.\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Remove all orphans (only one file with same size).\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Read first %v bytes (%v) of files. Smaller files are read whole and skip reading last bytes and hashing.\n", ai, readSize, duplikaatti.BytesToHuman(uint64(readSize)))
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Remove all orphans.\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Read last %v bytes (%v) of files.\n", ai, readSize, duplikaatti.BytesToHuman(uint64(readSize)))
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Remove all orphans.\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Hash whole files (or compare them byte by byte with -compare).\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Select file with checksum X not to be removed using keep rules and add rest of the files to a duplicates list.\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Optionally review duplicates list (-tui or -interactive).\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "  %v. Remove duplicates.\n", ai)
+		ai++
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+
+		fmt.Fprintf(flag.CommandLine.Output(), "Keep rules:\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  Files outside archives and reference files are always kept. Then rules are applied in given order until one of them prefers a file.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  Remaining ties are broken by path and inode.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    priority         keep file with highest priority\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    oldest, newest   keep file by modification time\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    shortest-path    keep file with shortest path (longest-path for the opposite)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    shallowest       keep file with least directories in path (deepest for the opposite)\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    prefer:<regexp>  keep file whose path matches regular expression\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    avoid:<regexp>   remove file whose path matches regular expression\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    name:<pattern>   keep file whose name matches glob pattern\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "    owner:<user>     keep file owned by user name or uid\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  Example: -keep avoid:/tmp/ -keep priority -keep newest\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+
+		fmt.Fprintf(flag.CommandLine.Output(), "(c) %v %v- / %v\n", AUTHOR, YEAR, HOMEPAGE)
+		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 && filesFrom == `` && fdupesPlanPath == `` && len(refs) == 0 && len(prios) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if useTUI && interactive {
+		fmt.Println(`-tui and -interactive can't be used together`)
+		os.Exit(1)
+	}
+
+	if planPath != `` && !interactive {
+		fmt.Println(`-plan requires -interactive`)
+		os.Exit(1)
+	}
+
+	if filesFrom == `-` && fdupesPlanPath == `-` {
+		fmt.Println(`-files-from and -fdupes-plan can't both read stdin`)
+		os.Exit(1)
+	}
+
+	// Answers are read from stdin
+	if (useTUI || interactive) && (filesFrom == `-` || fdupesPlanPath == `-`) {
+		fmt.Println(`-tui and -interactive read answers from stdin, -files-from and -fdupes-plan can't read stdin with them`)
+		os.Exit(1)
+	}
+
+	if emitScript != `` && actuallyRemove {
+		fmt.Println(`-emit-script and -remove can't be used together`)
+		os.Exit(1)
+	}
+
+	keepPolicy, err := duplikaatti.NewRulePolicy(keepRules)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var script *duplikaatti.ScriptAction
+	var scriptFile *os.File
+
+	if emitScript != `` {
+		scriptFile, err = os.OpenFile(emitScript, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		script, err = duplikaatti.NewScriptAction(scriptFile, scriptCommand)
+		if err != nil {
+			fmt.Println(err)
+			scriptFile.Close()
+			os.Remove(emitScript)
+			os.Exit(1)
+		}
+	}
+
+	roots, listPrio := getScanRoots(refs, prios, flag.Args())
+
+	// Check that all given arguments exist
+	for _, root := range roots {
+		_, err := os.Stat(root.Path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var fileList io.Reader
+
+	switch filesFrom {
+	case ``:
+	case `-`:
+		fileList = os.Stdin
+	default:
+		f, err := os.Open(filesFrom)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		fileList = f
+	}
+
+	var fdupesPlan *fdupesPlan
+
+	if fdupesPlanPath != `` {
+		plan, err := loadFdupesPlan(fdupesPlanPath, fdupesSameLine)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fdupesPlan = &plan
+	}
+
+	if actuallyRemove {
+		log.Printf("ACTUALLY DELETING FILES! PRESS CTRL+C TO ABORT!")
+	} else {
+		log.Printf("Note: Not actually deleting files (dry run)")
+	}
+
+	now := time.Now()
+	ctx := cancelOnSignal()
+
+	errs := duplikaatti.NewErrorLog(log.Default())
+
+	// Log what was done when stopped before removing anything
+	exitIfAborted := func() {
+		if ctx.Err() == nil {
+			return
+		}
+
+		logErrors(errs)
+		log.Printf(`Aborted, nothing was removed.`)
+		log.Printf(`Took %v`, time.Since(now).Truncate(time.Second))
+		os.Exit(EXIT_ABORTED)
+	}
+
+	var progressOut io.Writer = os.Stderr
+	if progressFd != 2 {
+		progressOut = os.NewFile(uintptr(progressFd), `progress`)
+	}
+
+	prog, err := duplikaatti.NewProgress(progressFormat, progressOut, now)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer prog.Close()
+
+	finder, err := duplikaatti.NewFinder(duplikaatti.FinderOptions{
+		ReadSize:   readSize,
+		KeepPolicy: keepPolicy,
+		Progress:   prog,
+		SpillDir:   spillDir,
+		Compare:    compare,
+		Errors:     errs,
+		Logger:     log.Default(),
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	// Files are compared while directories are still being scanned
+	files := make(chan duplikaatti.File, 1024)
+
+	var dirTree *duplikaatti.DirectoryTree
+	if findDirs || similarPercent > 0 {
+		dirTree = duplikaatti.NewDirectoryTree()
+	}
+
+	scanner := duplikaatti.NewScanner(duplikaatti.ScannerOptions{
+		FollowSymlinks: followSymlinks,
+		Archives:       archives,
+		Directories:    dirTree,
+		Progress:       prog,
+		Output:         files,
+		Errors:         errs,
+		Logger:         log.Default(),
+	})
+
+	type findResult struct {
+		groups []duplikaatti.DuplicateGroup
+		err    error
+	}
+
+	found := make(chan findResult)
+
+	go func() {
+		groups, err := finder.FindStream(ctx, files)
+		found <- findResult{groups, err}
+	}()
+
+	log.Printf(`Generating file list..`)
+	prog.StartStage(duplikaatti.STAGE_SCAN, 0, 0)
+
+	// First get a recursive file listing
+	for _, root := range roots {
+		err = scanner.AddRoot(ctx, root)
+		if err != nil {
+			exitIfAborted()
+			log.Printf(`%v`, err)
+			os.Exit(1)
+		}
+	} // End of recursive scan
+
+	if fileList != nil {
+		err = scanner.AddFileList(ctx, fileList, duplikaatti.Source{Priority: listPrio})
+		if err != nil {
+			exitIfAborted()
+			log.Printf(`error reading file list: %v`, err)
+			os.Exit(1)
+		}
+	}
+
+	if fdupesPlan != nil {
+		for _, path := range fdupesPlan.paths {
+			scanner.AddPath(path, duplikaatti.Source{Priority: listPrio})
+		}
+	}
+
+	if followSymlinks {
+		err = scanner.ScanSymlinks(ctx)
+		if err != nil {
+			exitIfAborted()
+			log.Printf(`%v`, err)
+			os.Exit(1)
+		}
+	}
+
+	close(files)
+	prog.FinishStage(duplikaatti.STAGE_SCAN)
+
+	// Now we have list of files
+
+	log.Printf(`File list generated..`)
+
+	res := <-found
+	groups, err := res.groups, res.err
+	if err != nil {
+		exitIfAborted()
+		log.Printf(`%v`, err)
+		os.Exit(1)
+	}
+
+	if findDirs {
+		logDirectoryGroups(dirTree.Duplicates(groups))
+	}
+
+	if similarPercent > 0 {
+		logSimilarDirectories(dirTree.Similar(groups, similarPercent))
+	}
+
+	if fdupesPlan != nil {
+		groups = fdupesPlan.Apply(groups)
+		log.Printf(`%v groups left after applying fdupes plan`, len(groups))
+	}
+
+	var action duplikaatti.Action = duplikaatti.DryRunAction{}
+	if actuallyRemove {
+		action = duplikaatti.RemoveAction{}
+	} else if script != nil {
+		action = script
+	}
+
+	deletedCount := uint64(0)
+	deletedSize := uint64(0)
+
+	if useTUI {
+		var commit bool
+
+		groups, commit, err = runTUI(ctx, groups)
+		if err != nil {
+			exitIfAborted()
+			log.Printf(`%v`, err)
+			os.Exit(1)
+		}
+
+		if !commit {
+			log.Printf(`Quit without committing, nothing was removed.`)
+			os.Exit(0)
+		}
+	}
+
+	var rootPaths []string
+	for _, root := range roots {
+		rootPaths = append(rootPaths, root.Path)
+	}
+
+	if summaryTop > 0 {
+		logSpaceSummary(duplikaatti.SummarizeSpace(groups, rootPaths), summaryTop)
+	}
+
+	if reportHTML != `` {
+		err = writeHTMLReport(reportHTML, groups, rootPaths)
+		if err != nil {
+			log.Printf(`error writing HTML report: %v`, err)
+			os.Exit(EXIT_ERROR)
+		}
+
+		log.Printf(`HTML report written to %v`, reportHTML)
+	}
+
+	if rdfindResults != `` {
+		err = writeRdfindResults(rdfindResults, groups, rootPaths)
+		if err != nil {
+			log.Printf(`error writing rdfind results: %v`, err)
+			os.Exit(EXIT_ERROR)
+		}
+
+		log.Printf(`rdfind results written to %v`, rdfindResults)
+	}
+
+	if fdupesOut != `` {
+		err = writeFdupesFile(fdupesOut, groups, fdupesSameLine, fdupesSize)
+		if err != nil {
+			log.Printf(`error writing fdupes output: %v`, err)
+			os.Exit(EXIT_ERROR)
+		}
+	}
+
+	removeFiles, removeBytes := uint64(0), uint64(0)
+	for _, v := range groups {
+		for _, f := range v.Duplicates() {
+			removeFiles++
+			removeBytes += f.Size
+		}
+	}
+
+	// Progress would get mixed with the questions
+	if !interactive {
+		prog.StartStage(duplikaatti.STAGE_REMOVE, removeFiles, removeBytes)
+	}
+
+	var prompt *prompter
+
+	if interactive {
+		plan, err := loadPlan(planPath)
+		if err != nil {
+			log.Printf(`%v`, err)
+			os.Exit(1)
+		}
+
+		var planFile io.Writer
+
+		if planPath != `` {
+			f, err := os.OpenFile(planPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Printf(`%v`, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			planFile = f
+		}
+
+		p := newPrompter(os.Stdin, os.Stdout, plan, planFile, len(groups))
+		prompt = &p
+	}
+
+	aborted := false
+	processedGroups := 0
+
+groupLoop:
+	for _, v := range groups {
+		if ctx.Err() != nil {
+			aborted = true
+			break
+		}
+
+		if prompt != nil {
+			process, quit, err := prompt.Ask(ctx, &v)
+			if err != nil {
+				if ctx.Err() != nil {
+					aborted = true
+					break
+				}
+
+				log.Printf(`%v`, err)
+				os.Exit(1)
+			}
+
+			if quit {
+				log.Printf(`Quit, rest of the groups were not processed.`)
+				break
+			}
+
+			if !process {
+				processedGroups++
+				continue
+			}
+		}
+
+		for idx, f := range v.Files {
+			// Stop before next file, rest of the group is left untouched
+			if ctx.Err() != nil && idx > 0 {
+				aborted = true
+				break groupLoop
+			}
+
+			if idx == 0 {
+				log.Printf(`Keeping %v (%v)`, f.Path, v.KeepRule)
+				continue
+			}
+
+			if f.Reference {
+				log.Printf(`Keeping reference %v`, f.Path)
+				continue
+			}
+
+			if f.Outside {
+				log.Printf(`Keeping %v outside the roots`, f.Path)
+				continue
+			}
+
+			if f.IsArchiveMember() {
+				log.Printf(`Keeping archive member %v`, f.Path)
+				continue
+			}
+
+			log.Printf(`Deleting %v`, f.Path)
+			prog.SetCurrent(duplikaatti.STAGE_REMOVE, f.Path)
+			prog.Add(duplikaatti.STAGE_REMOVE, 1, f.Size)
+
+			err := action.Apply(v.Keep(), f)
+			if err != nil {
+				errs.Add(duplikaatti.OP_ACTION, f.Path, err)
+				continue
+			}
+
+			deletedSize += uint64(f.Size)
+			deletedCount++
+		}
+
+		processedGroups++
+	}
+
+	prog.FinishStage(duplikaatti.STAGE_REMOVE)
+
+	if script != nil {
+		err = script.Close()
+		if err == nil {
+			err = scriptFile.Close()
+		}
+
+		if err != nil {
+			log.Printf(`error writing script: %v`, err)
+			os.Exit(EXIT_ERROR)
+		}
+
+		log.Printf(`Script written to %v`, emitScript)
+	}
+
+	log.Printf(`Deleted %v files, %v`, deletedCount, duplikaatti.BytesToHuman(deletedSize))
+	log.Printf(`Took %v`, time.Since(now).Truncate(time.Second))
+	logErrors(errs)
+
+	if aborted {
+		log.Printf(`Aborted, %v of %v groups were processed.`, processedGroups, len(groups))
+		os.Exit(EXIT_ABORTED)
+	}
+
+	log.Printf(`Done.`)
+	os.Exit(exitStatus(errs))
+
+}
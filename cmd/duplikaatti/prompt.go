@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// Decision made for a duplicate group, stored in a plan file
+type planDecision struct {
+	Skip bool   // Don't touch the group
+	Keep string // Path of the kept file
+}
+
+// loadPlan reads decisions from a plan file. Missing file is an empty plan.
+//
+// Format is one group per line:
+//
+//	<sha256>\tkeep\t<path>
+//	<sha256>\tskip
+func loadPlan(path string) (plan map[string]planDecision, err error) {
+	plan = map[string]planDecision{}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return plan, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 65536), 1048576)
+
+	lineNum := 0
+
+	for sc.Scan() {
+		lineNum++
+		line := sc.Text()
+
+		if line == `` || strings.HasPrefix(line, `#`) {
+			continue
+		}
+
+		parts := strings.SplitN(line, "\t", 3)
+
+		switch {
+		case len(parts) == 2 && parts[1] == `skip`:
+			plan[parts[0]] = planDecision{Skip: true}
+		case len(parts) == 3 && parts[1] == `keep`:
+			plan[parts[0]] = planDecision{Keep: parts[2]}
+		default:
+			return nil, fmt.Errorf(`%v:%v: invalid plan line %#v`, path, lineNum, line)
+		}
+	}
+
+	return plan, sc.Err()
+}
+
+// Line read from the input
+type promptLine struct {
+	line string
+	err  error
+}
+
+// prompter asks what to do with each duplicate group
+type prompter struct {
+	in        *bufio.Reader
+	lines     chan promptLine // Lines read in the background, see readLine
+	out       io.Writer
+	plan      map[string]planDecision // Decisions from earlier sessions
+	planFile  io.Writer               // Where decisions are written, can be nil
+	acceptAll bool                    // Accept suggestion for rest of the groups
+	asked     int
+	total     int
+}
+
+func newPrompter(in io.Reader, out io.Writer, plan map[string]planDecision, planFile io.Writer, total int) prompter {
+	if plan == nil {
+		plan = map[string]planDecision{}
+	}
+
+	return prompter{
+		in:       bufio.NewReader(in),
+		out:      out,
+		plan:     plan,
+		planFile: planFile,
+		total:    total,
+	}
+}
+
+// Ask what to do with the group. Group is modified so that the chosen file is
+// first. Returns false when group should not be touched and quit when user wants
+// to stop.
+// Returns ctx.Err() when cancelled while waiting for an answer.
+func (p *prompter) Ask(ctx context.Context, g *duplikaatti.DuplicateGroup) (process bool, quit bool, err error) {
+	p.asked++
+
+	// Decision from earlier session
+	if d, ok := p.plan[g.Hash]; ok {
+		if d.Skip {
+			return false, false, nil
+		}
+
+		for idx, f := range g.Files {
+			if f.Path == d.Keep {
+				g.SetKeep(idx)
+				return true, false, nil
+			}
+		}
+
+		// Kept file is gone, ask again
+	}
+
+	if p.acceptAll {
+		return true, false, p.record(g, false)
+	}
+
+	fmt.Fprintf(p.out, "\nGroup %v/%v, %v files of %v (sha256 %.16v):\n", p.asked, p.total, len(g.Files), duplikaatti.BytesToHuman(g.Files[0].Size), g.Hash)
+
+	for idx, f := range g.Files {
+		action := `remove`
+
+		if idx == 0 {
+			action = fmt.Sprintf(`keep (%v)`, g.KeepRule)
+		} else if f.Reference {
+			action = `keep (reference)`
+		} else if f.Outside {
+			action = `keep (outside roots)`
+		} else if f.IsArchiveMember() {
+			action = `keep (archive member)`
+		}
+
+		fmt.Fprintf(p.out, "  [%v] %v %v\n", idx+1, f.Path, action)
+	}
+
+	for {
+		fmt.Fprintf(p.out, "Enter to accept, keep <N>, skip, all or quit: ")
+
+		line, err := p.readLine(ctx)
+		if err != nil {
+			if err == io.EOF {
+				return false, true, nil
+			}
+
+			return false, false, err
+		}
+
+		answer := strings.Fields(line)
+
+		if len(answer) == 0 {
+			return true, false, p.record(g, false)
+		}
+
+		switch answer[0] {
+		case `y`, `yes`:
+			return true, false, p.record(g, false)
+
+		case `keep`, `k`:
+			if len(answer) != 2 {
+				fmt.Fprintf(p.out, "Usage: keep <N>\n")
+				continue
+			}
+
+			n, err := strconv.Atoi(answer[1])
+			if err != nil || n < 1 || n > len(g.Files) {
+				fmt.Fprintf(p.out, "Invalid file number %#v, expected 1-%v\n", answer[1], len(g.Files))
+				continue
+			}
+
+			if n > 1 && !g.SetKeep(n-1) {
+				fmt.Fprintf(p.out, "Archive members and files outside the roots are never kept instead of other files\n")
+				continue
+			}
+
+			return true, false, p.record(g, false)
+
+		case `skip`, `s`:
+			return false, false, p.record(g, true)
+
+		case `all`, `a`:
+			p.acceptAll = true
+			return true, false, p.record(g, false)
+
+		case `quit`, `q`:
+			return false, true, nil
+
+		default:
+			fmt.Fprintf(p.out, "Unknown answer %#v\n", answer[0])
+		}
+	}
+}
+
+// Read a line from the input. Reading is done in the background so that
+// waiting for an answer can be cancelled.
+func (p *prompter) readLine(ctx context.Context) (line string, err error) {
+	if p.lines == nil {
+		p.lines = make(chan promptLine)
+
+		go func() {
+			for {
+				line, err := p.in.ReadString('\n')
+				p.lines <- promptLine{line: line, err: err}
+
+				if err != nil {
+					close(p.lines)
+					return
+				}
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ``, ctx.Err()
+	case l, ok := <-p.lines:
+		if !ok {
+			return ``, io.EOF
+		}
+
+		return l.line, l.err
+	}
+}
+
+// Write decision to the plan file
+func (p *prompter) record(g *duplikaatti.DuplicateGroup, skip bool) (err error) {
+	if p.planFile == nil {
+		return nil
+	}
+
+	if skip {
+		_, err = fmt.Fprintf(p.planFile, "%v\tskip\n", g.Hash)
+	} else {
+		_, err = fmt.Fprintf(p.planFile, "%v\tkeep\t%v\n", g.Hash, g.Files[0].Path)
+	}
+
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// Duplicate types of rdfind results file
+const (
+	RDFIND_FIRST_OCCURRENCE = `DUPTYPE_FIRST_OCCURRENCE` // Kept file
+	RDFIND_WITHIN_SAME_TREE = `DUPTYPE_WITHIN_SAME_TREE` // Duplicate under the same root as the kept file
+	RDFIND_OUTSIDE_TREE     = `DUPTYPE_OUTSIDE_TREE`     // Duplicate under another root
+)
+
+// Write duplicate groups in the results.txt format of rdfind. Groups are
+// numbered from 1, the kept file has the number and the other files its
+// negation. Only files which would be removed are written after the kept
+// file, so reference files and archive members are left out. Like rdfind
+// each root gets its own priority by scan order starting from 1, files
+// outside the roots get the priority after the last root.
+func writeRdfindResults(path string, groups []duplikaatti.DuplicateGroup, roots []string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	fmt.Fprintf(w, "# Automatically generated\n")
+	fmt.Fprintf(w, "# duptype id depth size device inode priority name\n")
+
+	// Root index starting from 0, files outside the roots get len(roots)
+	rootOf := func(file duplikaatti.File) int {
+		idx := duplikaatti.RootIndex(roots, file.Path)
+		if idx == -1 {
+			return len(roots)
+		}
+
+		return idx
+	}
+
+	id := 0
+
+	for _, g := range groups {
+		dupes := g.Duplicates()
+		if len(dupes) == 0 {
+			continue
+		}
+
+		id++
+		keep := g.Keep()
+		keepRoot := rootOf(keep)
+
+		writeRdfindLine(w, RDFIND_FIRST_OCCURRENCE, id, keepRoot, keep)
+
+		for _, file := range dupes {
+			duptype := RDFIND_WITHIN_SAME_TREE
+			fileRoot := rootOf(file)
+
+			if fileRoot != keepRoot {
+				duptype = RDFIND_OUTSIDE_TREE
+			}
+
+			writeRdfindLine(w, duptype, -id, fileRoot, file)
+		}
+	}
+
+	fmt.Fprintf(w, "# end of file\n")
+
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeRdfindLine(w io.Writer, duptype string, id int, root int, file duplikaatti.File) {
+	fmt.Fprintf(w, "%v %v %v %v %v %v %v %v\n", duptype, id, file.Depth, file.Size, file.Device, file.INode, root+1, file.Path)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/user"
+	"sort"
+	"strings"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// Exit statuses
+const (
+	EXIT_OK            = 0
+	EXIT_ERROR         = 1   // Nothing was done
+	EXIT_READ_ERRORS   = 3   // Some files couldn't be scanned or read, duplicates may be missing
+	EXIT_ACTION_ERRORS = 4   // Some duplicates couldn't be removed
+	EXIT_ABORTED       = 130 // Stopped by SIGINT or SIGTERM
+)
+
+// Log collected errors by category
+func logErrors(errs *duplikaatti.ErrorLog) {
+	all := errs.Errors()
+	if len(all) == 0 {
+		return
+	}
+
+	counts := errs.Categories()
+
+	var categories []string
+	for c := range counts {
+		categories = append(categories, c)
+	}
+	sort.Strings(categories)
+
+	var summary []string
+	for _, c := range categories {
+		summary = append(summary, fmt.Sprintf(`%v %v`, counts[c], c))
+	}
+
+	log.Printf(`Errors: %v (%v)`, len(all), strings.Join(summary, `, `))
+
+	for _, e := range all {
+		log.Printf(`  [%v] %v`, e.Category, e)
+	}
+}
+
+// Log directories whose whole contents are identical
+func logDirectoryGroups(dirs []duplikaatti.DuplicateDirectoryGroup) {
+	log.Printf(`Identical directories: %v groups`, len(dirs))
+
+	for _, d := range dirs {
+		log.Printf(`%v directories with %v files, %v each:`, len(d.Directories), d.Files, duplikaatti.BytesToHuman(d.Size))
+
+		for _, dir := range d.Directories {
+			log.Printf(`  %v`, dir)
+		}
+	}
+}
+
+// Log pairs of directories which share files
+func logSimilarDirectories(similar []duplikaatti.SimilarDirectories) {
+	log.Printf(`Similar directories: %v pairs`, len(similar))
+
+	for _, s := range similar {
+		for idx := range s.Directories {
+			log.Printf(`  %v shares %.0f%% of bytes (%v of %v) with %v`, s.Directories[idx], s.Percent(idx),
+				duplikaatti.BytesToHuman(s.Shared[idx]), duplikaatti.BytesToHuman(s.Size[idx]), s.Directories[1-idx])
+		}
+	}
+}
+
+// Log reclaimable space with top entries of each list
+func logSpaceSummary(s duplikaatti.SpaceSummary, top int) {
+	log.Printf(`Reclaimable space: %v files, %v`, s.Files, duplikaatti.BytesToHuman(s.Bytes))
+
+	logUsage(`By scan root`, s.Roots, top, nil)
+	logUsage(`By top-level directory`, s.Directories, top, nil)
+	logUsage(`By owner`, s.Owners, top, ownerName)
+	logUsage(`By extension`, s.Extensions, top, nil)
+}
+
+func logUsage(title string, list []duplikaatti.SpaceUsage, top int, name func(string) string) {
+	log.Printf(`%v:`, title)
+
+	for idx, u := range list {
+		if idx == top {
+			log.Printf(`  .. %v more`, len(list)-top)
+			break
+		}
+
+		n := u.Name
+		if name != nil {
+			n = name(n)
+		}
+
+		log.Printf(`  %10v %8v files  %v`, duplikaatti.BytesToHuman(u.Bytes), u.Files, n)
+	}
+}
+
+// User name and uid, only uid when the user isn't known
+func ownerName(uid string) string {
+	u, err := user.LookupId(uid)
+	if err != nil {
+		return uid
+	}
+
+	return fmt.Sprintf(`%v (%v)`, u.Username, uid)
+}
+
+// Exit status for a run which wasn't stopped
+func exitStatus(errs *duplikaatti.ErrorLog) int {
+	if errs.Count(duplikaatti.OP_ACTION) > 0 {
+		return EXIT_ACTION_ERRORS
+	}
+
+	if errs.Count(``) > 0 {
+		return EXIT_READ_ERRORS
+	}
+
+	return EXIT_OK
+}
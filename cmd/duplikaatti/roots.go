@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// referenceFlag collects read-only reference directories given with -ref
+type referenceFlag []string
+
+func (f *referenceFlag) String() string {
+	return strings.Join(*f, `, `)
+}
+
+func (f *referenceFlag) Set(value string) error {
+	if value == `` {
+		return fmt.Errorf(`empty path`)
+	}
+
+	*f = append(*f, value)
+	return nil
+}
+
+// priorityFlag collects directories with explicit priority given with -prio <priority>:<path>
+type priorityFlag []duplikaatti.Root
+
+func (f *priorityFlag) String() string {
+	var l []string
+
+	for _, r := range *f {
+		l = append(l, fmt.Sprintf(`%v:%v`, r.Source.Priority, r.Path))
+	}
+
+	return strings.Join(l, `, `)
+}
+
+func (f *priorityFlag) Set(value string) error {
+	parts := strings.SplitN(value, `:`, 2)
+
+	if len(parts) != 2 || parts[1] == `` {
+		return fmt.Errorf(`invalid value %#v, expected <priority>:<path>`, value)
+	}
+
+	prio, err := strconv.ParseUint(parts[0], 10, 8)
+	if err != nil {
+		return fmt.Errorf(`invalid priority %#v, expected 0-%v`, parts[0], math.MaxUint8)
+	}
+
+	*f = append(*f, duplikaatti.Root{
+		Path: parts[1],
+		Source: duplikaatti.Source{
+			Priority: uint8(prio),
+		},
+	})
+
+	return nil
+}
+
+// getScanRoots returns directories and files in the order they should be scanned.
+// Reference directories are scanned first so that files hard linked to them are
+// never removed, then directories with explicit priority from highest to lowest and
+// last the plain arguments. Plain arguments get implicit priority by their order
+// starting from the highest, or from one below the lowest explicit priority so
+// that -prio always outranks them.
+func getScanRoots(refs referenceFlag, prios priorityFlag, args []string) (roots []duplikaatti.Root, nextPrio uint8) {
+	for _, path := range refs {
+		roots = append(roots, duplikaatti.Root{
+			Path: path,
+			Source: duplikaatti.Source{
+				Priority:  math.MaxUint8,
+				Reference: true,
+			},
+		})
+	}
+
+	sorted := make([]duplikaatti.Root, len(prios))
+	copy(sorted, prios)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Source.Priority > sorted[j].Source.Priority
+	})
+
+	roots = append(roots, sorted...)
+
+	nextPrio = math.MaxUint8
+
+	if len(sorted) > 0 {
+		nextPrio = sorted[len(sorted)-1].Source.Priority
+
+		if nextPrio > 0 {
+			nextPrio--
+		}
+	}
+
+	for _, path := range args {
+		roots = append(roots, duplikaatti.Root{
+			Path: path,
+			Source: duplikaatti.Source{
+				Priority: nextPrio,
+			},
+		})
+
+		if nextPrio > 0 {
+			nextPrio--
+		}
+	}
+
+	return roots, nextPrio
+}
+
+// keepRuleFlag collects keep rules given with -keep
+type keepRuleFlag []string
+
+func (f *keepRuleFlag) String() string {
+	return strings.Join(*f, `, `)
+}
+
+func (f *keepRuleFlag) Set(value string) error {
+	_, err := duplikaatti.ParseKeepRule(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, value)
+	return nil
+}
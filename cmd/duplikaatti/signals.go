@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// cancelOnSignal returns context which is cancelled on first SIGINT or SIGTERM
+// so that current file can be finished. Second signal exits immediately.
+func cancelOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		log.Printf(`Got %v, stopping after current file. Send again to stop immediately.`, sig)
+		cancel()
+
+		sig = <-sigs
+		log.Printf(`Got %v, stopping immediately.`, sig)
+		os.Exit(EXIT_ABORTED)
+	}()
+
+	return ctx
+}
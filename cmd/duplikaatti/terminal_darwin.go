@@ -0,0 +1,58 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// makeRaw disables line buffering and echo of given terminal. Returned function restores previous state.
+func makeRaw(fd uintptr) (restore func() error, err error) {
+	var old syscall.Termios
+
+	err = ioctl(fd, syscall.TIOCGETA, unsafe.Pointer(&old))
+	if err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	err = ioctl(fd, syscall.TIOCSETA, unsafe.Pointer(&raw))
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return ioctl(fd, syscall.TIOCSETA, unsafe.Pointer(&old))
+	}, nil
+}
+
+// getTerminalSize returns width and height of given terminal
+func getTerminalSize(fd uintptr) (width int, height int, err error) {
+	var ws winsize
+
+	err = ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(ws.Col), int(ws.Row), nil
+}
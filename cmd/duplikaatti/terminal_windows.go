@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	enableEchoInput                 = 0x0004
+	enableLineInput                 = 0x0002
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+type coord struct {
+	X int16
+	Y int16
+}
+
+type smallRect struct {
+	Left   int16
+	Top    int16
+	Right  int16
+	Bottom int16
+}
+
+type consoleScreenBufferInfo struct {
+	Size              coord
+	CursorPosition    coord
+	Attributes        uint16
+	Window            smallRect
+	MaximumWindowSize coord
+}
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode))
+	if r == 0 {
+		return os.NewSyscallError("SetConsoleMode", err)
+	}
+
+	return nil
+}
+
+// makeRaw disables line buffering and echo of given console and enables ANSI escape
+// sequences. Returned function restores previous state.
+func makeRaw(fd uintptr) (restore func() error, err error) {
+	in := syscall.Handle(fd)
+	out := syscall.Handle(os.Stdout.Fd())
+
+	var oldIn, oldOut uint32
+
+	err = syscall.GetConsoleMode(in, &oldIn)
+	if err != nil {
+		return nil, os.NewSyscallError("GetConsoleMode", err)
+	}
+
+	err = syscall.GetConsoleMode(out, &oldOut)
+	if err != nil {
+		return nil, os.NewSyscallError("GetConsoleMode", err)
+	}
+
+	err = setConsoleMode(in, (oldIn&^(enableEchoInput|enableLineInput))|enableVirtualTerminalInput)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setConsoleMode(out, oldOut|enableVirtualTerminalProcessing)
+	if err != nil {
+		setConsoleMode(in, oldIn)
+		return nil, err
+	}
+
+	return func() error {
+		setConsoleMode(out, oldOut)
+		return setConsoleMode(in, oldIn)
+	}, nil
+}
+
+// getTerminalSize returns width and height of the console
+func getTerminalSize(fd uintptr) (width int, height int, err error) {
+	var info consoleScreenBufferInfo
+
+	r, _, err := procGetConsoleScreenBufferInfo.Call(os.Stdout.Fd(), uintptr(unsafe.Pointer(&info)))
+	if r == 0 {
+		return 0, 0, os.NewSyscallError("GetConsoleScreenBufferInfo", err)
+	}
+
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
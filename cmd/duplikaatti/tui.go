@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raspi/duplikaatti"
+)
+
+// reviewer holds decisions made while reviewing duplicate groups
+type reviewer struct {
+	groups  []duplikaatti.DuplicateGroup
+	skip    []bool
+	current int    // Current group
+	cursor  int    // Selected file in current group
+	bulkDir string // Directory for bulk operations
+}
+
+func newReviewer(groups []duplikaatti.DuplicateGroup) reviewer {
+	r := reviewer{
+		groups: groups,
+		skip:   make([]bool, len(groups)),
+	}
+
+	r.resetBulkDir()
+
+	return r
+}
+
+// Plan returns groups which were not skipped
+func (r *reviewer) Plan() (plan []duplikaatti.DuplicateGroup) {
+	for idx, g := range r.groups {
+		if r.skip[idx] {
+			continue
+		}
+
+		plan = append(plan, g)
+	}
+
+	return plan
+}
+
+// Keep moves given file of given group first so that it's kept and tells if
+// the group changed
+func (r *reviewer) Keep(group int, file int) (changed bool) {
+	return r.groups[group].SetKeep(file)
+}
+
+// KeepDirectory keeps a file from given directory in every group which has one
+// which can be kept. Returns amount of changed groups.
+func (r *reviewer) KeepDirectory(dir string) (changed int) {
+	for gidx, g := range r.groups {
+		for fidx, f := range g.Files {
+			if !isInDirectory(f.Path, dir) {
+				continue
+			}
+
+			if fidx == 0 {
+				break
+			}
+
+			if r.Keep(gidx, fidx) {
+				changed++
+				break
+			}
+		}
+	}
+
+	return changed
+}
+
+// AvoidDirectory keeps a file outside of given directory in every group where
+// the kept file is in given directory. Returns amount of changed groups.
+func (r *reviewer) AvoidDirectory(dir string) (changed int) {
+	for gidx, g := range r.groups {
+		if !isInDirectory(g.Files[0].Path, dir) {
+			continue
+		}
+
+		for fidx, f := range g.Files {
+			if !isInDirectory(f.Path, dir) && r.Keep(gidx, fidx) {
+				changed++
+				break
+			}
+		}
+	}
+
+	return changed
+}
+
+// SkipDirectory skips every group which has a file in given directory
+func (r *reviewer) SkipDirectory(dir string) (changed int) {
+	for gidx, g := range r.groups {
+		for _, f := range g.Files {
+			if isInDirectory(f.Path, dir) {
+				if !r.skip[gidx] {
+					r.skip[gidx] = true
+					changed++
+				}
+				break
+			}
+		}
+	}
+
+	return changed
+}
+
+// Move cursor to given group
+func (r *reviewer) setGroup(idx int) {
+	if idx < 0 || idx >= len(r.groups) {
+		return
+	}
+
+	r.current = idx
+	r.cursor = 0
+	r.resetBulkDir()
+}
+
+// Move cursor to given file
+func (r *reviewer) setCursor(idx int) {
+	if idx < 0 || idx >= len(r.groups[r.current].Files) {
+		return
+	}
+
+	r.cursor = idx
+	r.resetBulkDir()
+}
+
+// Bulk operations target directory of the selected file by default
+func (r *reviewer) resetBulkDir() {
+	if len(r.groups) == 0 {
+		return
+	}
+
+	r.bulkDir = filepath.Dir(r.groups[r.current].Files[r.cursor].Path)
+}
+
+// Is path inside given directory or its subdirectories
+func isInDirectory(path string, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(os.PathSeparator))+string(os.PathSeparator))
+}
+
+// Keys
+const (
+	keyUnknown = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyEnter
+)
+
+// Read a single key press, returns either special key or a character
+func readKey(r *bufio.Reader) (key int, ch byte, err error) {
+	ch, err = r.ReadByte()
+	if err != nil {
+		return keyUnknown, 0, err
+	}
+
+	switch ch {
+	case '\r', '\n':
+		return keyEnter, ch, nil
+	case 0x1b: // Escape sequence
+		if r.Buffered() < 2 {
+			return keyUnknown, ch, nil
+		}
+
+		b1, _ := r.ReadByte()
+		b2, _ := r.ReadByte()
+
+		if b1 != '[' && b1 != 'O' {
+			return keyUnknown, ch, nil
+		}
+
+		switch b2 {
+		case 'A':
+			return keyUp, 0, nil
+		case 'B':
+			return keyDown, 0, nil
+		case 'C':
+			return keyRight, 0, nil
+		case 'D':
+			return keyLeft, 0, nil
+		}
+
+		return keyUnknown, ch, nil
+	}
+
+	return keyUnknown, ch, nil
+}
+
+// Key read from the terminal
+type keyPress struct {
+	key int
+	ch  byte
+	err error
+}
+
+// Read keys in the background so that waiting for a key can be cancelled
+func readKeys(r *bufio.Reader) <-chan keyPress {
+	keys := make(chan keyPress)
+
+	go func() {
+		for {
+			key, ch, err := readKey(r)
+			keys <- keyPress{key: key, ch: ch, err: err}
+
+			if err != nil {
+				close(keys)
+				return
+			}
+		}
+	}()
+
+	return keys
+}
+
+// runTUI lets user review duplicate groups in terminal. Returns groups to be
+// processed or false if user quit without committing. Terminal is restored and
+// ctx.Err() returned when cancelled.
+func runTUI(ctx context.Context, groups []duplikaatti.DuplicateGroup) (plan []duplikaatti.DuplicateGroup, commit bool, err error) {
+	if len(groups) == 0 {
+		return groups, true, nil
+	}
+
+	restore, err := makeRaw(os.Stdin.Fd())
+	if err != nil {
+		return nil, false, fmt.Errorf(`stdin is not a terminal: %v`, err)
+	}
+	defer restore()
+
+	r := newReviewer(groups)
+	keys := readKeys(bufio.NewReader(os.Stdin))
+	out := bufio.NewWriter(os.Stdout)
+	status := ``
+
+	// Hide cursor, restore on exit
+	fmt.Fprint(out, "\x1b[?25l")
+	defer func() {
+		fmt.Fprint(out, "\x1b[?25h\x1b[H\x1b[2J")
+		out.Flush()
+	}()
+
+	for {
+		r.draw(out, status)
+		out.Flush()
+		status = ``
+
+		var kp keyPress
+
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case kp = <-keys:
+		}
+
+		if kp.err != nil {
+			return nil, false, kp.err
+		}
+
+		key, ch := kp.key, kp.ch
+
+		switch {
+		case key == keyUp || ch == 'k':
+			r.setCursor(r.cursor - 1)
+		case key == keyDown || ch == 'j':
+			r.setCursor(r.cursor + 1)
+		case key == keyLeft || ch == 'p':
+			r.setGroup(r.current - 1)
+		case key == keyRight || ch == 'n':
+			r.setGroup(r.current + 1)
+		case key == keyEnter || ch == ' ':
+			if r.cursor > 0 && !r.Keep(r.current, r.cursor) {
+				status = `Archive members and files outside the roots are never kept instead of other files`
+				break
+			}
+
+			r.cursor = 0
+		case ch == 's':
+			r.skip[r.current] = !r.skip[r.current]
+		case ch == 'u':
+			parent := filepath.Dir(r.bulkDir)
+			if parent != r.bulkDir {
+				r.bulkDir = parent
+			}
+		case ch == 'K':
+			status = fmt.Sprintf(`Keeping files from %v in %v groups`, r.bulkDir, r.KeepDirectory(r.bulkDir))
+		case ch == 'D':
+			status = fmt.Sprintf(`Removing files from %v in %v groups`, r.bulkDir, r.AvoidDirectory(r.bulkDir))
+		case ch == 'S':
+			status = fmt.Sprintf(`Skipped %v groups with files in %v`, r.SkipDirectory(r.bulkDir), r.bulkDir)
+		case ch == 'c':
+			return r.Plan(), true, nil
+		case ch == 'q':
+			return nil, false, nil
+		}
+	}
+}
+
+// Draw current group
+func (r *reviewer) draw(out *bufio.Writer, status string) {
+	width, height, err := getTerminalSize(os.Stdout.Fd())
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	line := func(format string, a ...interface{}) {
+		s := fmt.Sprintf(format, a...)
+		if len(s) > width {
+			s = s[:width]
+		}
+
+		fmt.Fprintf(out, "%v\x1b[K\r\n", s)
+	}
+
+	g := r.groups[r.current]
+	size := g.Files[0].Size
+
+	skipped := 0
+	for _, s := range r.skip {
+		if s {
+			skipped++
+		}
+	}
+
+	fmt.Fprint(out, "\x1b[H")
+
+	skipText := ``
+	if r.skip[r.current] {
+		skipText = `  [SKIPPED]`
+	}
+
+	line(`Group %v/%v  %v files of %v  wasted %v  sha256 %.16v%v`, r.current+1, len(r.groups), len(g.Files), duplikaatti.BytesToHuman(size), duplikaatti.BytesToHuman(size*uint64(len(g.Files)-1)), g.Hash, skipText)
+	line(`Kept by rule: %v  (%v groups skipped)`, g.KeepRule, skipped)
+	line(``)
+
+	// Header, footer and padding use 8 lines
+	visible := height - 8
+	if visible < 1 {
+		visible = 1
+	}
+
+	first := 0
+	if r.cursor >= visible {
+		first = r.cursor - visible + 1
+	}
+
+	for idx := first; idx < len(g.Files) && idx < first+visible; idx++ {
+		f := g.Files[idx]
+
+		pointer := ` `
+		if idx == r.cursor {
+			pointer = `>`
+		}
+
+		action := `del `
+		if idx == 0 {
+			action = `KEEP`
+		} else if f.Reference {
+			action = `REF `
+		} else if f.Outside {
+			action = `OUT `
+		} else if f.IsArchiveMember() {
+			action = `ARC `
+		}
+
+		line(`%v %v  prio %3v  %v  uid %-5v  inode %-10v  %v`, pointer, action, f.Priority, f.ModTime.Format(`2006-01-02 15:04:05`), f.Owner, f.INode, f.Path)
+	}
+
+	for idx := len(g.Files) - first; idx < visible; idx++ {
+		line(``)
+	}
+
+	line(``)
+	line(`Bulk directory: %v`, r.bulkDir)
+	line(`%v`, status)
+	line(`j/k move  n/p group  enter keep  s skip  u parent dir  K keep dir  D remove dir  S skip dir  c commit  q quit`)
+	fmt.Fprint(out, "\x1b[J")
+}
@@ -0,0 +1,351 @@
+package duplikaatti
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+)
+
+// Block size used when comparing files byte by byte
+const COMPARE_BLOCK_SIZE = 64 * 1024
+
+// Maximum amount of files a compare worker keeps open at the same time. Larger
+// groups are compared in batches.
+const COMPARE_MAX_OPEN = 128
+
+// findCompare groups candidates left after last bytes stage and compares files
+// of each group byte by byte instead of hashing them
+func (ds *Finder) findCompare(ctx context.Context, paths *pathTable, archives *archiveCache, last hasherWorker, small <-chan candidate) (groups []DuplicateGroup, err error) {
+	ds.progress.StartStage(STAGE_COMPARE, 0, 0)
+
+	collect := make(chan candidate, 100)
+
+	go ds.sieve(ctx, last.Results, last.stage, stageInput{
+		jobs:  collect,
+		stage: STAGE_COMPARE,
+	}, `Last bytes compared`)
+
+	candidates := map[candidateKey][]candidate{}
+
+	for small != nil || collect != nil {
+		var c candidate
+		var ok bool
+
+		select {
+		case c, ok = <-small:
+			if !ok {
+				small = nil
+				continue
+			}
+
+			ds.progress.AddTotal(STAGE_COMPARE, 1, c.Entry.Size)
+		case c, ok = <-collect:
+			if !ok {
+				collect = nil
+				continue
+			}
+		}
+
+		candidates[c.Key] = append(candidates[c.Key], c)
+	}
+
+	if ctx.Err() != nil {
+		ds.progress.FinishStage(STAGE_COMPARE)
+		return nil, ctx.Err()
+	}
+
+	identical := ds.compareGroups(ctx, paths, archives, candidates)
+	ds.progress.FinishStage(STAGE_COMPARE)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	ds.logger.Printf(`Files compared`)
+
+	return GetDuplicateList(identical, ds.keepPolicy), nil
+}
+
+// Compare groups in parallel. Returned groups are keyed by groupID.
+func (ds *Finder) compareGroups(ctx context.Context, paths *pathTable, archives *archiveCache, candidates map[candidateKey][]candidate) (m map[Checksum]map[uint64][]File) {
+	m = make(map[Checksum]map[uint64][]File)
+
+	jobs := make(chan []candidate)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < ds.workerCount; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for group := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				for _, set := range ds.compareFiles(ctx, paths, archives, group) {
+					files := make([]File, len(set))
+
+					for idx, c := range set {
+						files[idx] = paths.file(c.Entry)
+						files[idx].ModTime = c.ModTime
+						files[idx].Owner = c.Owner
+					}
+
+					id := groupID(set[0].Key, files)
+
+					mu.Lock()
+					m[id] = map[uint64][]File{set[0].Entry.Size: files}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for key, group := range candidates {
+		delete(candidates, key)
+
+		if len(group) < 2 {
+			continue
+		}
+
+		jobs <- group
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return m
+}
+
+// Identifier for a group of identical files which were compared without
+// hashing. It's derived from size, partial checksums and the first path so
+// that the same group gets the same identifier on the next run.
+func groupID(key candidateKey, files []File) (id Checksum) {
+	firstPath := files[0].Path
+
+	for _, f := range files[1:] {
+		if f.Path < firstPath {
+			firstPath = f.Path
+		}
+	}
+
+	h := sha256.New()
+
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], key.Size)
+
+	h.Write(size[:])
+	h.Write(key.First[:])
+	h.Write(key.Last[:])
+	h.Write([]byte(firstPath))
+
+	copy(id[:], h.Sum(nil))
+
+	return id
+}
+
+// compareFiles splits files of the same size to sets of identical files.
+// Groups larger than COMPARE_MAX_OPEN are compared in batches, so no more than
+// COMPARE_MAX_OPEN files are ever open.
+func (ds *Finder) compareFiles(ctx context.Context, paths *pathTable, archives *archiveCache, group []candidate) (sets [][]candidate) {
+	defer ds.progress.Add(STAGE_COMPARE, uint64(len(group)), 0)
+
+	if len(group) > COMPARE_MAX_OPEN {
+		return ds.compareBatches(ctx, paths, archives, group)
+	}
+
+	sets, _ = ds.partition(ctx, paths, archives, group)
+
+	return sets
+}
+
+// compareBatches compares the first file of the group byte by byte against
+// batches of the rest of the files. Files identical to it form a set and the
+// rest are compared again the same way until no files are left.
+func (ds *Finder) compareBatches(ctx context.Context, paths *pathTable, archives *archiveCache, group []candidate) (sets [][]candidate) {
+	// Files are read again when compared against another file
+	read := map[fileEntry]bool{}
+
+	for len(group) > 1 {
+		first := group[0]
+		set := []candidate{first}
+		var rest []candidate
+
+		for start := 1; start < len(group); start += COMPARE_MAX_OPEN - 1 {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			end := start + COMPARE_MAX_OPEN - 1
+			if end > len(group) {
+				end = len(group)
+			}
+
+			batch := append([]candidate{first}, group[start:end]...)
+
+			for _, c := range batch {
+				if read[c.Entry] {
+					ds.progress.AddTotal(STAGE_COMPARE, 0, c.Entry.Size)
+				}
+
+				read[c.Entry] = true
+			}
+
+			parts, unique := ds.partition(ctx, paths, archives, batch)
+			found := false
+
+			for _, part := range append(parts, unique...) {
+				if part[0].Entry != first.Entry {
+					rest = append(rest, part...)
+					continue
+				}
+
+				set[0] = part[0]
+				set = append(set, part[1:]...)
+				found = true
+			}
+
+			if !found {
+				// First file couldn't be read, compare the rest against another file
+				rest = append(rest, group[end:]...)
+				break
+			}
+		}
+
+		if len(set) > 1 {
+			sets = append(sets, set)
+		}
+
+		group = rest
+	}
+
+	return sets
+}
+
+// File opened for comparing
+type compareFile struct {
+	c   candidate
+	f   openedFile
+	buf []byte
+	n   int
+}
+
+// partition reads files block by block and splits them to sets of identical
+// files whenever their contents diverge. Files which differ from the rest are
+// closed right away, so for a pair of files reading stops at the first
+// differing block. Files which were read without errors but aren't identical
+// to any other file are returned in unique, one file per set.
+func (ds *Finder) partition(ctx context.Context, paths *pathTable, archives *archiveCache, group []candidate) (sets [][]candidate, unique [][]candidate) {
+	var open []*compareFile
+
+	for _, c := range group {
+		f, err := openEntry(paths, archives, c.Entry)
+		if err != nil {
+			ds.errors.Add(OP_READ, ``, err)
+			continue
+		}
+
+		c.ModTime = f.ModTime
+		c.Owner = f.Owner
+
+		open = append(open, &compareFile{
+			c:   c,
+			f:   f,
+			buf: make([]byte, COMPARE_BLOCK_SIZE),
+		})
+	}
+
+	active := [][]*compareFile{open}
+
+	for len(active) > 0 {
+		var next [][]*compareFile
+
+		for _, group := range active {
+			if ctx.Err() != nil {
+				closeCompareFiles(group)
+				continue
+			}
+
+			for _, cf := range group {
+				n, err := readFull(cf.f, cf.buf)
+				if err != nil && err != io.EOF {
+					ds.errors.Add(OP_READ, cf.f.Path, err)
+					n = -1 // Never equal to other files
+				}
+
+				cf.n = n
+				ds.progress.SetCurrent(STAGE_COMPARE, cf.f.Path)
+
+				if n > 0 {
+					ds.progress.Add(STAGE_COMPARE, 0, uint64(n))
+				}
+			}
+
+			for _, part := range splitByBlock(group) {
+				if len(part) < 2 {
+					closeCompareFiles(part)
+
+					if part[0].n >= 0 {
+						unique = append(unique, []candidate{part[0].c})
+					}
+
+					continue
+				}
+
+				if part[0].n != 0 {
+					next = append(next, part)
+					continue
+				}
+
+				// All files of the set reached the end
+				set := make([]candidate, len(part))
+				for idx, cf := range part {
+					set[idx] = cf.c
+				}
+
+				closeCompareFiles(part)
+
+				sets = append(sets, set)
+			}
+		}
+
+		active = next
+	}
+
+	return sets, unique
+}
+
+// Split files by the block which was read last
+func splitByBlock(group []*compareFile) (parts [][]*compareFile) {
+	for _, cf := range group {
+		found := false
+
+		for idx, part := range parts {
+			first := part[0]
+
+			if cf.n >= 0 && cf.n == first.n && bytes.Equal(cf.buf[:cf.n], first.buf[:first.n]) {
+				parts[idx] = append(parts[idx], cf)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			parts = append(parts, []*compareFile{cf})
+		}
+	}
+
+	return parts
+}
+
+func closeCompareFiles(files []*compareFile) {
+	for _, cf := range files {
+		cf.f.Close()
+	}
+}
@@ -0,0 +1,89 @@
+package duplikaatti
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestCompareFilesBatches(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	finder, err := NewFinder(FinderOptions{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := newPathTable()
+	archives := newArchiveCache(``)
+	defer archives.Close()
+
+	var group []candidate
+
+	add := func(name string, content string) {
+		path := filepath.Join(dir, name)
+
+		if content != `` {
+			err := ioutil.WriteFile(path, []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		group = append(group, candidate{Entry: paths.entry(File{Path: path, Size: 5})})
+	}
+
+	// Larger than the amount of files kept open, so files are compared in batches
+	count := 3 * COMPARE_MAX_OPEN
+	for i := 0; i < count; i++ {
+		add(fmt.Sprintf(`f%03d`, i), fmt.Sprintf(`set %v`, i%3))
+	}
+
+	add(`unique`, `other`)
+	add(`missing`, ``)
+
+	errs := &ErrorLog{}
+	finder.errors = errs
+
+	sets := finder.compareFiles(context.Background(), paths, archives, group)
+
+	var sizes []int
+	for _, set := range sets {
+		sizes = append(sizes, len(set))
+
+		content, err := ioutil.ReadFile(paths.join(set[0].Entry.Dir, set[0].Entry.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range set[1:] {
+			other, err := ioutil.ReadFile(paths.join(c.Entry.Dir, c.Entry.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(other) != string(content) {
+				t.Fatalf(`%#v and %#v are in the same set`, string(content), string(other))
+			}
+		}
+	}
+
+	sort.Ints(sizes)
+
+	expected := []int{count / 3, count / 3, count / 3}
+	if fmt.Sprint(sizes) != fmt.Sprint(expected) {
+		t.Fatalf(`got sets of %v files, expected %v`, sizes, expected)
+	}
+
+	if errs.Count(OP_READ) != 1 {
+		t.Fatalf(`got %v read errors, expected one`, errs.Count(OP_READ))
+	}
+}
@@ -0,0 +1,254 @@
+package duplikaatti
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// DuplicateDirectoryGroup is a group of directories whose whole contents are identical
+type DuplicateDirectoryGroup struct {
+	Hash        string   // Hash of the directory tree
+	Directories []string // Directories, sorted by path
+	Files       int      // Amount of files in each directory and its subdirectories
+	Size        uint64   // Size of files in each directory and its subdirectories
+}
+
+// Scanned directory
+type dirNode struct {
+	files   int          // Amount of files found in the directory
+	bytes   uint64       // Size of files found in the directory
+	entries []dirEntry   // Files and subdirectories with their hashes
+	subdirs []string     // Listed subdirectories
+	done    bool         // Hash is calculated
+	hash    Checksum     // Valid when complete
+	size    uint64       // Size of files in the tree
+	count   int          // Amount of files in the tree
+	parent  *dirNode     // Parent directory when it was listed too
+	dupe    bool         // Belongs to a group of identical directories
+	state   dirTreeState // Result of hashing
+}
+
+type dirTreeState int
+
+const (
+	dirEmpty      dirTreeState = iota // No files in the tree
+	dirComplete                       // Every file of the tree is a duplicate of some file
+	dirIncomplete                     // Tree has an unique file, so it can't be identical to another tree
+)
+
+// Name and content hash of a file or a subdirectory
+type dirEntry struct {
+	Name  string
+	IsDir bool
+	Hash  string
+}
+
+// DirectoryTree records directories listed by Scanner and the amount of files
+// found in each so that whole directory trees and similar directories can be
+// found after files are compared. A directory is identical to another when
+// they have the same names with the same contents. Only files which Scanner
+// passes forward are counted, so empty files and other skipped files don't
+// make directories different.
+type DirectoryTree struct {
+	dirs map[string]*dirNode
+}
+
+func NewDirectoryTree() *DirectoryTree {
+	return &DirectoryTree{
+		dirs: map[string]*dirNode{},
+	}
+}
+
+// Add listed directory
+func (t *DirectoryTree) addDirectory(dir string) {
+	if t == nil {
+		return
+	}
+
+	dir = filepath.Clean(dir)
+
+	if _, ok := t.dirs[dir]; !ok {
+		t.dirs[dir] = &dirNode{}
+	}
+}
+
+// Add file found in a listed directory
+func (t *DirectoryTree) addFile(f File) {
+	if t == nil || f.IsArchiveMember() {
+		return
+	}
+
+	if d, ok := t.dirs[filepath.Dir(f.Path)]; ok {
+		d.files++
+		d.bytes += f.Size
+	}
+}
+
+// Duplicates returns groups of directories whose whole trees are identical.
+// The hash of a directory is calculated from sorted names and hashes of its
+// files and subdirectories, so only directories where every file is in one
+// of the given duplicate groups can be identical. Subdirectories of
+// identical directories are left out when all of them are inside identical
+// directories. Groups are ordered by size, largest first, and then by hash.
+func (t *DirectoryTree) Duplicates(groups []DuplicateGroup) (dupes []DuplicateDirectoryGroup) {
+	for _, d := range t.dirs {
+		*d = dirNode{files: d.files, bytes: d.bytes}
+	}
+
+	for _, g := range groups {
+		for _, f := range g.Files {
+			if f.IsArchiveMember() {
+				continue
+			}
+
+			d, ok := t.dirs[filepath.Dir(f.Path)]
+			if !ok {
+				continue
+			}
+
+			d.entries = append(d.entries, dirEntry{
+				Name: filepath.Base(f.Path),
+				Hash: g.Hash,
+			})
+			d.size += f.Size
+		}
+	}
+
+	for path, d := range t.dirs {
+		if parent, ok := t.dirs[filepath.Dir(path)]; ok && parent != d {
+			d.parent = parent
+			parent.subdirs = append(parent.subdirs, path)
+		}
+	}
+
+	trees := map[Checksum][]string{}
+
+	for path := range t.dirs {
+		d := t.hashDirectory(path)
+		if d.state == dirComplete {
+			trees[d.hash] = append(trees[d.hash], path)
+		}
+	}
+
+	for _, paths := range trees {
+		if len(paths) < 2 {
+			continue
+		}
+
+		for _, path := range paths {
+			t.dirs[path].dupe = true
+		}
+	}
+
+	for hash, paths := range trees {
+		if len(paths) < 2 || t.insideDuplicates(paths) {
+			continue
+		}
+
+		sort.Strings(paths)
+		d := t.dirs[paths[0]]
+
+		dupes = append(dupes, DuplicateDirectoryGroup{
+			Hash:        hash.String(),
+			Directories: paths,
+			Files:       d.count,
+			Size:        d.size,
+		})
+	}
+
+	sort.SliceStable(dupes, func(i, j int) bool {
+		if dupes[i].Size != dupes[j].Size {
+			return dupes[i].Size > dupes[j].Size
+		}
+
+		return dupes[i].Hash < dupes[j].Hash
+	})
+
+	return dupes
+}
+
+// Tells if every directory is inside an identical directory, so they're
+// already reported with their parents
+func (t *DirectoryTree) insideDuplicates(paths []string) bool {
+	for _, path := range paths {
+		parent := t.dirs[path].parent
+		if parent == nil || !parent.dupe {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Calculate hash of directory tree
+func (t *DirectoryTree) hashDirectory(path string) *dirNode {
+	d := t.dirs[path]
+	if d.done {
+		return d
+	}
+
+	d.done = true
+	d.count = len(d.entries)
+
+	if len(d.entries) != d.files {
+		d.state = dirIncomplete
+	}
+
+	for _, sub := range d.subdirs {
+		s := t.hashDirectory(sub)
+
+		switch s.state {
+		case dirEmpty:
+			// Directories without files don't make trees different
+			continue
+		case dirIncomplete:
+			d.state = dirIncomplete
+		}
+
+		d.entries = append(d.entries, dirEntry{
+			Name:  filepath.Base(sub),
+			IsDir: true,
+			Hash:  s.hash.String(),
+		})
+		d.size += s.size
+		d.count += s.count
+	}
+
+	if d.state == dirIncomplete {
+		d.entries = nil
+		return d
+	}
+
+	if len(d.entries) == 0 {
+		d.state = dirEmpty
+		return d
+	}
+
+	d.state = dirComplete
+
+	sort.Slice(d.entries, func(i, j int) bool {
+		if d.entries[i].Name != d.entries[j].Name {
+			return d.entries[i].Name < d.entries[j].Name
+		}
+
+		return !d.entries[i].IsDir && d.entries[j].IsDir
+	})
+
+	h := sha256.New()
+
+	for _, e := range d.entries {
+		kind := `f`
+		if e.IsDir {
+			kind = `d`
+		}
+
+		fmt.Fprintf(h, "%v\x00%v\x00%v\x00", kind, e.Name, e.Hash)
+	}
+
+	copy(d.hash[:], h.Sum(nil))
+	d.entries = nil
+
+	return d
+}
@@ -0,0 +1,27 @@
+// Package duplikaatti finds duplicate files. Algorithm idea is from rdfind.
+//
+// Scanner generates a file list from directories, files and file lists. Finder
+// narrows the list down by file sizes, first and last bytes and finally whole
+// file checksums and returns groups of duplicates. With ScannerOptions.Output
+// and Finder.FindStream the stages run while directories are still scanned.
+// With ScannerOptions.Archives files inside zip and tar archives are compared
+// too, they're only reported and never removed. DirectoryTree given in
+// ScannerOptions.Directories finds identical and similar directory trees from
+// the duplicate groups. KeepPolicy selects which file of a group is kept and
+// Action is done to the rest of the files. With ScannerOptions.FollowSymlinks
+// links are only collected while scanning, Scanner.ScanSymlinks must be called
+// after all roots are added to scan their targets.
+//
+//	scanner := duplikaatti.NewScanner(duplikaatti.ScannerOptions{FollowSymlinks: true})
+//	err := scanner.AddRoot(ctx, duplikaatti.Root{Path: `/mnt/storage`})
+//	err = scanner.ScanSymlinks(ctx)
+//
+//	finder, err := duplikaatti.NewFinder(duplikaatti.FinderOptions{})
+//	groups, err := finder.Find(ctx, scanner.Files())
+//
+//	for _, g := range groups {
+//		for _, f := range g.Duplicates() {
+//			err = duplikaatti.RemoveAction{}.Apply(g.Keep(), f)
+//		}
+//	}
+package duplikaatti
@@ -0,0 +1,145 @@
+package duplikaatti
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Error categories
+const (
+	ERROR_PERMISSION = `permission` // Access denied
+	ERROR_VANISHED   = `vanished`   // File was removed after it was found
+	ERROR_IO         = `io`         // Any other error
+	ERROR_CHANGED    = `changed`    // File was modified after it was found
+)
+
+// Operations where errors happen
+const (
+	OP_SCAN   = `scan`   // Listing directories
+	OP_READ   = `read`   // Reading files for comparing
+	OP_ACTION = `action` // Removing or other action done to a duplicate
+)
+
+// ErrChanged is returned when file size is not what it was when file was found
+var ErrChanged = errors.New(`file has changed`)
+
+// FileError is an error which happened while handling a file
+type FileError struct {
+	Op       string // OP_SCAN, OP_READ or OP_ACTION
+	Category string // ERROR_PERMISSION, ERROR_VANISHED, ERROR_IO or ERROR_CHANGED
+	Path     string
+	Err      error
+}
+
+func (e FileError) Error() string {
+	if e.Path == `` {
+		return fmt.Sprintf(`%v: %v`, e.Op, e.Err)
+	}
+
+	return fmt.Sprintf(`%v %v: %v`, e.Op, e.Path, e.Err)
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
+// newFileError categorizes error. Path is taken from *os.PathError when not given.
+func newFileError(op string, path string, err error) FileError {
+	var pe *os.PathError
+	if path == `` && errors.As(err, &pe) {
+		path = pe.Path
+	}
+
+	return FileError{
+		Op:       op,
+		Category: errorCategory(err),
+		Path:     path,
+		Err:      err,
+	}
+}
+
+func errorCategory(err error) string {
+	switch {
+	case errors.Is(err, ErrChanged):
+		return ERROR_CHANGED
+	case errors.Is(err, os.ErrPermission):
+		return ERROR_PERMISSION
+	case errors.Is(err, os.ErrNotExist):
+		return ERROR_VANISHED
+	default:
+		return ERROR_IO
+	}
+}
+
+// ErrorLog collects errors of files which couldn't be handled. Errors are
+// also logged as they happen when a logger is given. It's safe for concurrent
+// use. All methods can be called on a nil *ErrorLog, then errors are ignored.
+type ErrorLog struct {
+	mu     sync.Mutex
+	errors []FileError
+	logger *log.Logger
+}
+
+// NewErrorLog creates error log, logger is optional
+func NewErrorLog(logger *log.Logger) *ErrorLog {
+	return &ErrorLog{
+		logger: loggerOrDiscard(logger),
+	}
+}
+
+// Add error which happened in given operation. Path is optional.
+func (l *ErrorLog) Add(op string, path string, err error) {
+	if l == nil {
+		return
+	}
+
+	fe := newFileError(op, path, err)
+
+	if l.logger != nil {
+		l.logger.Printf(`error: %v`, fe)
+	}
+
+	l.mu.Lock()
+	l.errors = append(l.errors, fe)
+	l.mu.Unlock()
+}
+
+// Errors returns errors collected so far
+func (l *ErrorLog) Errors() []FileError {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	errs := make([]FileError, len(l.errors))
+	copy(errs, l.errors)
+
+	return errs
+}
+
+// Count returns amount of errors in given operation, all operations when op is empty
+func (l *ErrorLog) Count(op string) (count int) {
+	for _, e := range l.Errors() {
+		if op == `` || e.Op == op {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Categories returns amount of errors in each category
+func (l *ErrorLog) Categories() map[string]int {
+	counts := map[string]int{}
+
+	for _, e := range l.Errors() {
+		counts[e.Category]++
+	}
+
+	return counts
+}
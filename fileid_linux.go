@@ -0,0 +1,25 @@
+package duplikaatti
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// getFileID returns device and inode of given path, symbolic links are followed
+func getFileID(path string) (id fileID, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return id, err
+	}
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return id, fmt.Errorf(`couldn't stat: %v`, path)
+	}
+
+	return fileID{
+		Device: uint64(stat.Dev),
+		INode:  stat.Ino,
+	}, nil
+}
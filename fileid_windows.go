@@ -0,0 +1,31 @@
+package duplikaatti
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns volume serial number and file index of given path, symbolic links are followed
+func getFileID(path string) (id fileID, err error) {
+	pathptr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return id, os.NewSyscallError("UTF16PtrFromString", err)
+	}
+
+	// FILE_FLAG_BACKUP_SEMANTICS is required for opening directories
+	h, err := syscall.CreateFile(pathptr, 0, 0, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return id, os.NewSyscallError("CreateFile", err)
+	}
+	defer syscall.CloseHandle(h)
+
+	var fi syscall.ByHandleFileInformation
+	if err = syscall.GetFileInformationByHandle(h, &fi); err != nil {
+		return id, os.NewSyscallError("GetFileInformationByHandle", err)
+	}
+
+	return fileID{
+		Device: uint64(fi.VolumeSerialNumber),
+		INode:  uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow),
+	}, nil
+}
@@ -1,21 +1,64 @@
-package main
+package duplikaatti
 
 import (
-	"github.com/raspi/dirscanner"
+	"time"
 )
 
-type fileInfo struct {
-	Priority uint8  // Priority
-	Path     string // Path to file
-	INode    uint64 // INode
-	Size     uint64 // File size
+// File is a file found by Scanner
+type File struct {
+	Priority  uint8     // Priority
+	Reference bool      // Is in a reference directory (never removed)
+	Outside   bool      // Found through a symbolic link outside the roots (never removed nor kept instead of files in the roots)
+	Path      string    // Path to file
+	INode     uint64    // INode
+	Device    uint64    // Device (windows: volume serial number)
+	Depth     uint32    // Amount of directories between scanned directory and the file, 0 for files given directly
+	Size      uint64    // File size
+	ModTime   time.Time // Modification time, set when file is hashed
+	Owner     uint32    // Owner uid, set when file is hashed
+	Archive   string    // Archive which contains the file, empty for normal files
+	Member    string    // Path of the file inside Archive
 }
 
-func newFileInfo(priority uint8, info dirscanner.FileInformation) fileInfo {
-	return fileInfo{
-		Priority: priority,
-		Path:     info.Path,
-		INode:    info.Identifier,
-		Size:     info.Size,
+// IsArchiveMember tells if file is inside an archive. Archive members are
+// only reported, they're never removed.
+func (f File) IsArchiveMember() bool {
+	return f.Archive != ``
+}
+
+// Reference files, archive members and files outside the roots are never removed
+func (f File) removable() bool {
+	return !f.Reference && !f.Outside && !f.IsArchiveMember()
+}
+
+// Source tells where file was found
+type Source struct {
+	Priority  uint8 // Priority, higher priority files are kept
+	Reference bool  // Files from reference directories are only used for matching and never removed
+	Outside   bool  // Files behind symbolic links outside the roots are only used for matching
+}
+
+// Root is a directory or file to be scanned
+type Root struct {
+	Path   string
+	Source Source
+}
+
+func newFile(src Source, info fileInformation) File {
+	return File{
+		Priority:  src.Priority,
+		Reference: src.Reference,
+		Outside:   src.Outside,
+		Path:      info.Path,
+		INode:     info.Identifier,
+		Device:    info.Device,
+		Depth:     info.Depth,
+		Size:      info.Size,
 	}
 }
+
+// Unique identifier of a file or directory
+type fileID struct {
+	Device uint64 // Device (windows: volume serial number)
+	INode  uint64 // INode (windows: file index)
+}
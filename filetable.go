@@ -0,0 +1,308 @@
+package duplikaatti
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Checksum is a SHA256 sum
+type Checksum [sha256.Size]byte
+
+func (c Checksum) String() string {
+	return fmt.Sprintf(`%x`, c[:])
+}
+
+// pathTable interns directories so that files in the same directory share one
+// copy of the directory path. It's safe for concurrent use.
+type pathTable struct {
+	mu    sync.RWMutex
+	dirs  []string
+	index map[string]uint32
+}
+
+func newPathTable() *pathTable {
+	return &pathTable{
+		index: map[string]uint32{},
+	}
+}
+
+// Split path to interned directory and file name
+func (t *pathTable) split(path string) (dir uint32, name string) {
+	d, name := filepath.Split(path)
+
+	t.mu.RLock()
+	dir, ok := t.index[d]
+	t.mu.RUnlock()
+
+	if ok {
+		return dir, name
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dir, ok = t.index[d]
+	if !ok {
+		dir = uint32(len(t.dirs))
+		t.dirs = append(t.dirs, d)
+		t.index[d] = dir
+	}
+
+	return dir, name
+}
+
+// Join interned directory and file name back to a path
+func (t *pathTable) join(dir uint32, name string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.dirs[dir] + name
+}
+
+// Len returns amount of interned directories
+func (t *pathTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return len(t.dirs)
+}
+
+// fileEntry is compact form of File used while finding duplicates
+type fileEntry struct {
+	Dir       uint32 // Directory in pathTable
+	Name      string
+	Size      uint64
+	INode     uint64
+	Device    uint64
+	Depth     uint32
+	Priority  uint8
+	Reference bool
+	Outside   bool
+	Member    string // Path inside archive when Dir and Name are path of an archive
+}
+
+func (t *pathTable) entry(f File) fileEntry {
+	path := f.Path
+	if f.IsArchiveMember() {
+		path = f.Archive
+	}
+
+	dir, name := t.split(path)
+
+	return fileEntry{
+		Dir:       dir,
+		Name:      name,
+		Size:      f.Size,
+		INode:     f.INode,
+		Device:    f.Device,
+		Depth:     f.Depth,
+		Priority:  f.Priority,
+		Reference: f.Reference,
+		Outside:   f.Outside,
+		Member:    f.Member,
+	}
+}
+
+func (t *pathTable) file(e fileEntry) File {
+	f := File{
+		Priority:  e.Priority,
+		Reference: e.Reference,
+		Outside:   e.Outside,
+		Path:      t.join(e.Dir, e.Name),
+		INode:     e.INode,
+		Device:    e.Device,
+		Depth:     e.Depth,
+		Size:      e.Size,
+	}
+
+	if e.Member != `` {
+		f.Archive = f.Path
+		f.Member = e.Member
+		f.Path = f.Archive + ARCHIVE_SEPARATOR + f.Member
+	}
+
+	return f
+}
+
+// Size of fileEntry without the name and member in spill file
+const spillHeaderSize = 4 + 8 + 8 + 8 + 4 + 1 + 1 + 4 + 4
+
+// sizeGroups holds the first file of each size until another file of the same
+// size is seen. Most files usually have an unique size, so after spillAfter
+// files are waiting in memory the rest of the waiting files are written to a
+// temporary file in spillDir and only their offsets are kept in memory.
+type sizeGroups struct {
+	pending      map[uint64]fileEntry // Waiting file in memory
+	spilled      map[uint64]int64     // Offset of waiting file in spill file
+	forwarded    map[uint64]struct{}  // Sizes which were already passed forward
+	spilledCount int
+	spillDir     string // Spilling is disabled when empty
+	spillAfter   int
+	spill        *os.File
+	spillW       *bufio.Writer
+	offset       int64
+	logger       *log.Logger
+}
+
+func newSizeGroups(spillDir string, spillAfter int, logger *log.Logger) *sizeGroups {
+	return &sizeGroups{
+		logger:     logger,
+		pending:    map[uint64]fileEntry{},
+		spilled:    map[uint64]int64{},
+		forwarded:  map[uint64]struct{}{},
+		spillDir:   spillDir,
+		spillAfter: spillAfter,
+	}
+}
+
+// Add file. Returns files which should be passed forward, the waiting file of
+// the same size is returned with the first file which has the same size.
+func (g *sizeGroups) Add(e fileEntry) (forward []fileEntry) {
+	if _, ok := g.forwarded[e.Size]; ok {
+		return []fileEntry{e}
+	}
+
+	if first, ok := g.pending[e.Size]; ok {
+		delete(g.pending, e.Size)
+		g.forwarded[e.Size] = struct{}{}
+
+		return []fileEntry{first, e}
+	}
+
+	if off, ok := g.spilled[e.Size]; ok {
+		delete(g.spilled, e.Size)
+		g.forwarded[e.Size] = struct{}{}
+
+		first, err := g.read(off)
+		if err != nil {
+			g.logger.Printf(`error: reading spill file: %v`, err)
+			return []fileEntry{e}
+		}
+
+		return []fileEntry{first, e}
+	}
+
+	if g.spillDir != `` && len(g.pending) >= g.spillAfter {
+		off, err := g.write(e)
+		if err == nil {
+			g.spilled[e.Size] = off
+			g.spilledCount++
+			return nil
+		}
+
+		// Keep rest of the files in memory
+		g.logger.Printf(`error: writing spill file: %v`, err)
+		g.spillDir = ``
+	}
+
+	g.pending[e.Size] = e
+
+	return nil
+}
+
+// Spilled returns amount of files written to the spill file
+func (g *sizeGroups) Spilled() int {
+	return g.spilledCount
+}
+
+// Close removes the spill file
+func (g *sizeGroups) Close() {
+	g.pending = nil
+	g.spilled = nil
+	g.forwarded = nil
+
+	if g.spill == nil {
+		return
+	}
+
+	g.spill.Close()
+	os.Remove(g.spill.Name())
+	g.spill = nil
+}
+
+// Write file to the end of spill file
+func (g *sizeGroups) write(e fileEntry) (off int64, err error) {
+	if g.spill == nil {
+		g.spill, err = ioutil.TempFile(g.spillDir, `duplikaatti-spill-`)
+		if err != nil {
+			return 0, err
+		}
+
+		g.spillW = bufio.NewWriterSize(g.spill, 65536)
+	}
+
+	var hdr [spillHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], e.Dir)
+	binary.LittleEndian.PutUint64(hdr[4:], e.Size)
+	binary.LittleEndian.PutUint64(hdr[12:], e.INode)
+	binary.LittleEndian.PutUint64(hdr[20:], e.Device)
+	binary.LittleEndian.PutUint32(hdr[28:], e.Depth)
+	hdr[32] = e.Priority
+	if e.Reference {
+		hdr[33] |= 1
+	}
+	if e.Outside {
+		hdr[33] |= 2
+	}
+	binary.LittleEndian.PutUint32(hdr[34:], uint32(len(e.Name)))
+	binary.LittleEndian.PutUint32(hdr[38:], uint32(len(e.Member)))
+
+	_, err = g.spillW.Write(hdr[:])
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = g.spillW.WriteString(e.Name + e.Member)
+	if err != nil {
+		return 0, err
+	}
+
+	off = g.offset
+	g.offset += int64(spillHeaderSize + len(e.Name) + len(e.Member))
+
+	return off, nil
+}
+
+// Read file from given offset of spill file
+func (g *sizeGroups) read(off int64) (e fileEntry, err error) {
+	err = g.spillW.Flush()
+	if err != nil {
+		return e, err
+	}
+
+	var hdr [spillHeaderSize]byte
+
+	_, err = g.spill.ReadAt(hdr[:], off)
+	if err != nil {
+		return e, err
+	}
+
+	nameLen := binary.LittleEndian.Uint32(hdr[34:])
+	name := make([]byte, nameLen+binary.LittleEndian.Uint32(hdr[38:]))
+
+	_, err = g.spill.ReadAt(name, off+spillHeaderSize)
+	if err != nil && err != io.EOF {
+		return e, err
+	}
+
+	return fileEntry{
+		Dir:       binary.LittleEndian.Uint32(hdr[0:]),
+		Name:      string(name[:nameLen]),
+		Member:    string(name[nameLen:]),
+		Size:      binary.LittleEndian.Uint64(hdr[4:]),
+		INode:     binary.LittleEndian.Uint64(hdr[12:]),
+		Device:    binary.LittleEndian.Uint64(hdr[20:]),
+		Depth:     binary.LittleEndian.Uint32(hdr[28:]),
+		Priority:  hdr[32],
+		Reference: hdr[33]&1 != 0,
+		Outside:   hdr[33]&2 != 0,
+	}, nil
+}
@@ -0,0 +1,65 @@
+package duplikaatti
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSizeGroupsSpill(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := newSizeGroups(dir, 2, nil)
+	defer g.Close()
+
+	entry := func(name string, size uint64) fileEntry {
+		return fileEntry{Name: name, Size: size, Priority: 3, Reference: true, Member: `m`}
+	}
+
+	steps := []struct {
+		add     fileEntry
+		forward []string
+	}{
+		{entry(`a`, 1), nil},
+		{entry(`b`, 2), nil},
+		{entry(`c`, 1), []string{`a`, `c`}},
+		// Forwarded sizes don't count as waiting files, so these stay in memory
+		{entry(`d`, 3), nil},
+		{entry(`e`, 1), []string{`e`}},
+		// Two files are waiting, the rest are spilled
+		{entry(`f`, 4), nil},
+		{entry(`g`, 5), nil},
+		{entry(`h`, 4), []string{`f`, `h`}},
+		{entry(`i`, 5), []string{`g`, `i`}},
+		{entry(`j`, 5), []string{`j`}},
+	}
+
+	for _, s := range steps {
+		var names []string
+
+		for _, e := range g.Add(s.add) {
+			names = append(names, e.Name)
+
+			if e != entry(e.Name, e.Size) {
+				t.Fatalf(`got %+v, expected %+v`, e, entry(e.Name, e.Size))
+			}
+		}
+
+		if !reflect.DeepEqual(names, s.forward) {
+			t.Fatalf(`adding %v: got %v, expected %v`, s.add.Name, names, s.forward)
+		}
+	}
+
+	if g.Spilled() != 2 {
+		t.Fatalf(`got %v spilled files, expected 2`, g.Spilled())
+	}
+
+	if len(g.pending) != 2 {
+		t.Fatalf(`got %v files waiting in memory, expected 2`, len(g.pending))
+	}
+}
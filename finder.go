@@ -0,0 +1,354 @@
+package duplikaatti
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// FinderOptions are options for NewFinder
+type FinderOptions struct {
+	Workers    int         // Amount of files read in parallel, defaults to number of CPUs
+	ReadSize   int64       // Bytes read from beginning and end of files, must be power of two, defaults to MEBIBYTE
+	KeepPolicy KeepPolicy  // Selects which file is kept, defaults to DefaultKeepRules
+	Progress   *Progress   // Optional progress reporting
+	SpillDir   string      // Directory for temporary file where files waiting for another file of the same size are kept, disabled when empty. Also used for decompressed archive members.
+	SpillAfter int         // Files waiting in memory before spilling to SpillDir, defaults to SPILL_AFTER
+	Compare    bool        // Compare files byte by byte instead of hashing whole files
+	Errors     *ErrorLog   // Optional collection of errors
+	Logger     *log.Logger // Optional, progress messages are logged here
+}
+
+// Default amount of files with unique size kept in memory before spilling to disk
+const SPILL_AFTER = 1000000
+
+// Finder finds duplicates from a list of files. Algorithm is from rdfind.
+type Finder struct {
+	progress    *Progress
+	workerCount int
+	readSize    int64
+	keepPolicy  KeepPolicy
+	spillDir    string
+	spillAfter  int
+	compare     bool
+	errors      *ErrorLog
+	logger      *log.Logger
+}
+
+func NewFinder(opts FinderOptions) (ds *Finder, err error) {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+
+	if opts.ReadSize == 0 {
+		opts.ReadSize = MEBIBYTE
+	}
+
+	if !isPowerOfTwo(uint64(opts.ReadSize)) {
+		return nil, fmt.Errorf(`read size (%v) is not power of two`, opts.ReadSize)
+	}
+
+	if opts.SpillAfter <= 0 {
+		opts.SpillAfter = SPILL_AFTER
+	}
+
+	if opts.KeepPolicy == nil {
+		opts.KeepPolicy, err = NewRulePolicy(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Finder{
+		progress:    opts.Progress,
+		workerCount: opts.Workers,
+		readSize:    opts.ReadSize,
+		keepPolicy:  opts.KeepPolicy,
+		spillDir:    opts.SpillDir,
+		spillAfter:  opts.SpillAfter,
+		compare:     opts.Compare,
+		errors:      opts.Errors,
+		logger:      loggerOrDiscard(opts.Logger),
+	}, nil
+}
+
+// Find returns groups of duplicate files from given files. Files are expected
+// to be unique (no hard links to the same inode).
+func (ds *Finder) Find(ctx context.Context, files []File) (groups []DuplicateGroup, err error) {
+	in := make(chan File)
+
+	go func() {
+		defer close(in)
+
+		for _, f := range files {
+			select {
+			case <-ctx.Done():
+				return
+			case in <- f:
+			}
+		}
+	}()
+
+	return ds.FindStream(ctx, in)
+}
+
+// FindStream returns groups of duplicate files from files sent to given channel.
+// Stages run at the same time: a file is passed to the next stage as soon as
+// another file with the same size and checksums is seen, so files can be sent
+// while directories are still being scanned. Caller must close the channel once
+// all files are sent. Files are read from the channel until it's closed even
+// when ctx is cancelled.
+func (ds *Finder) FindStream(ctx context.Context, files <-chan File) (groups []DuplicateGroup, err error) {
+	paths := newPathTable()
+
+	// Workers have stopped when results are returned
+	archives := newArchiveCache(ds.spillDir)
+	defer archives.Close()
+
+	// All read errors are in the error log before returning
+	var drained sync.WaitGroup
+	defer drained.Wait()
+
+	first := ds.startWorker(ctx, paths, archives, READ_FIRST, &drained)
+	last := ds.startWorker(ctx, paths, archives, READ_LAST, &drained)
+
+	// Files no larger than read size are read whole when reading first bytes
+	// and don't need the later stages
+	small := make(chan candidate, 100)
+
+	go ds.sieveSizes(ctx, files, paths, archives, workerInput(first))
+	go ds.sieve(ctx, first.Results, first.stage, stageInput{
+		jobs:  last.Jobs,
+		stage: last.stage,
+		limit: uint64(ds.readSize),
+		small: small,
+	}, `First bytes compared`)
+
+	if ds.compare {
+		return ds.findCompare(ctx, paths, archives, last, small)
+	}
+
+	whole := ds.startWorker(ctx, paths, archives, READ_WHOLE, &drained)
+	go ds.sieve(ctx, last.Results, last.stage, workerInput(whole), `Last bytes compared`)
+
+	hashed := make(map[Checksum]map[uint64][]File)
+
+	results := whole.Results
+
+	for small != nil || results != nil {
+		var res candidate
+		var ok bool
+
+		select {
+		case res, ok = <-small:
+			if !ok {
+				small = nil
+				continue
+			}
+		case res, ok = <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+		}
+
+		if hashed[res.Hash] == nil {
+			hashed[res.Hash] = make(map[uint64][]File)
+		}
+
+		f := paths.file(res.Entry)
+		f.ModTime = res.ModTime
+		f.Owner = res.Owner
+
+		hashed[res.Hash][f.Size] = append(hashed[res.Hash][f.Size], f)
+	}
+
+	ds.progress.FinishStage(whole.stage)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	ds.logger.Printf(`Files hashed`)
+
+	return GetDuplicateList(hashed, ds.keepPolicy), nil
+}
+
+// Start workers of a stage. Errors are collected to the error log and drained
+// is done once all errors of the stage are collected.
+func (ds *Finder) startWorker(ctx context.Context, paths *pathTable, archives *archiveCache, rt ReadOperationType, drained *sync.WaitGroup) hasherWorker {
+	worker := newBytesWorker(ctx, ds.progress, ds.logger, paths, archives, ds.workerCount, ds.readSize, rt)
+
+	// Totals grow as earlier stages pass files forward
+	ds.progress.StartStage(worker.stage, 0, 0)
+
+	drained.Add(1)
+
+	go func(w *hasherWorker) {
+		defer drained.Done()
+
+		for e := range w.Errors {
+			ds.errors.Add(OP_READ, ``, e)
+		}
+	}(&worker)
+
+	return worker
+}
+
+// File waiting for another file with the same key
+type waitingFile struct {
+	Entry   fileEntry
+	ModTime time.Time // Set for small files which were read whole
+	Owner   uint32    // Set for small files which were read whole
+}
+
+// Candidates sent to the next stage
+type stageInput struct {
+	jobs  chan<- candidate
+	stage string           // Progress stage of the next stage
+	limit uint64           // Bytes read from each file in the next stage, zero for whole file
+	small chan<- candidate // Files which were already read whole skip the rest of the stages, can be nil
+	files uint64
+	bytes uint64
+}
+
+// Input of a worker stage
+func workerInput(w hasherWorker) stageInput {
+	in := stageInput{
+		jobs:  w.Jobs,
+		stage: w.stage,
+	}
+
+	if w.readType != READ_WHOLE {
+		in.limit = uint64(w.readSize)
+	}
+
+	return in
+}
+
+// Send candidate to the next stage. Nothing is sent when ctx is cancelled.
+func (ds *Finder) send(ctx context.Context, in *stageInput, c candidate) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	in.files++
+	in.bytes += c.Entry.Size
+
+	if in.small != nil && c.Entry.Size <= uint64(ds.readSize) {
+		select {
+		case <-ctx.Done():
+		case in.small <- c:
+		}
+
+		return
+	}
+
+	size := c.Entry.Size
+	if in.limit > 0 && size > in.limit {
+		size = in.limit
+	}
+
+	ds.progress.AddTotal(in.stage, 1, size)
+
+	select {
+	case <-ctx.Done():
+	case in.jobs <- c:
+	}
+}
+
+// sieveSizes passes files to the next stage once another file with the same
+// size is seen, so files with unique size are never read. Archive members
+// passed on are marked wanted so only they are decompressed. Jobs of the next
+// stage are closed when files is closed. When ctx is cancelled files is
+// drained without sending.
+func (ds *Finder) sieveSizes(ctx context.Context, files <-chan File, paths *pathTable, archives *archiveCache, out stageInput) {
+	defer close(out.jobs)
+
+	groups := newSizeGroups(ds.spillDir, ds.spillAfter, ds.logger)
+	defer groups.Close()
+
+	for f := range files {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		for _, e := range groups.Add(paths.entry(f)) {
+			if e.Member != `` {
+				archives.want(paths.join(e.Dir, e.Name), e.Member)
+			}
+
+			ds.send(ctx, &out, candidate{
+				Entry: e,
+				Key:   candidateKey{Size: e.Size},
+			})
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	ds.logger.Printf(`Sizes compared, %v directories`, paths.Len())
+
+	if groups.Spilled() > 0 {
+		ds.logger.Printf(`%v files were spilled to disk`, groups.Spilled())
+	}
+
+	ds.progress.Stats(out.files, out.bytes)
+}
+
+// sieve passes candidates from in to the next stage once another candidate with
+// the same key is seen. The first candidate of each key is held back until then,
+// so unique files are never sent forward. Stage which produced in is finished
+// and jobs of the next stage (and small, if set) are closed when in is closed.
+// When ctx is cancelled in is drained without sending.
+func (ds *Finder) sieve(ctx context.Context, in <-chan candidate, from string, out stageInput, doneMsg string) {
+	defer close(out.jobs)
+
+	if out.small != nil {
+		defer close(out.small)
+	}
+
+	// First file of a key, nil once the key has been sent forward
+	pending := map[candidateKey]*waitingFile{}
+
+	for c := range in {
+		first, seen := pending[c.Key]
+
+		if !seen {
+			pending[c.Key] = &waitingFile{
+				Entry:   c.Entry,
+				ModTime: c.ModTime,
+				Owner:   c.Owner,
+			}
+			continue
+		}
+
+		if first != nil {
+			// Whole file checksum is the same for small files
+			ds.send(ctx, &out, candidate{
+				Entry:   first.Entry,
+				Key:     c.Key,
+				Hash:    c.Hash,
+				ModTime: first.ModTime,
+				Owner:   first.Owner,
+			})
+			pending[c.Key] = nil
+		}
+
+		ds.send(ctx, &out, c)
+	}
+
+	ds.progress.FinishStage(from)
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	ds.logger.Printf(`%v`, doneMsg)
+	ds.progress.Stats(out.files, out.bytes)
+}
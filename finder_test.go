@@ -0,0 +1,234 @@
+package duplikaatti
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestScanAndFindStream(t *testing.T) {
+	root, dirs := makeTree(t, 3, 2)
+	defer os.RemoveAll(root)
+
+	// Files larger than read size which differ in the middle or at the end
+	big := bytes.Repeat([]byte(`0123456789abcdef`), 1024)
+
+	middle := append([]byte{}, big...)
+	middle[len(middle)/2] = 'x'
+
+	end := append([]byte{}, big...)
+	end[len(end)-1] = 'x'
+
+	for name, content := range map[string][]byte{
+		`big1`:   big,
+		`big2`:   big,
+		`middle`: middle,
+		`end`:    end,
+	} {
+		err := ioutil.WriteFile(filepath.Join(root, name), content, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, compare := range []bool{false, true} {
+		t.Run(map[bool]string{false: `hash`, true: `compare`}[compare], func(t *testing.T) {
+			errs := &ErrorLog{}
+
+			finder, err := NewFinder(FinderOptions{
+				Workers:  4,
+				ReadSize: 4096,
+				Compare:  compare,
+				Errors:   errs,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			files := make(chan File)
+			scanner := NewScanner(ScannerOptions{
+				Workers: 4,
+				Errors:  errs,
+				Output:  files,
+			})
+
+			type result struct {
+				groups []DuplicateGroup
+				err    error
+			}
+
+			done := make(chan result)
+
+			go func() {
+				groups, err := finder.FindStream(context.Background(), files)
+				done <- result{groups, err}
+			}()
+
+			err = scanner.AddRoot(context.Background(), Root{Path: root, Source: Source{Priority: 255}})
+			close(files)
+
+			res := <-done
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+
+			if len(errs.Errors()) != 0 {
+				t.Fatalf(`got errors %v`, errs.Errors())
+			}
+
+			if len(res.groups) != 2 {
+				t.Fatalf(`got %v groups, expected 2`, len(res.groups))
+			}
+
+			// Largest group first
+			var bigPaths []string
+			for _, f := range res.groups[0].Files {
+				bigPaths = append(bigPaths, filepath.Base(f.Path))
+			}
+
+			sort.Strings(bigPaths)
+
+			if !reflect.DeepEqual(bigPaths, []string{`big1`, `big2`}) {
+				t.Fatalf(`got first group %v, expected big1 and big2`, bigPaths)
+			}
+
+			shared := res.groups[1].Files
+			if len(shared) != dirs {
+				t.Fatalf(`got %v shared files, expected %v`, len(shared), dirs)
+			}
+
+			for _, f := range shared {
+				if filepath.Base(f.Path) != `shared` {
+					t.Fatalf(`unexpected file %v in group of shared files`, f.Path)
+				}
+			}
+
+			// Needed by the default keep rules
+			if res.groups[1].Keep().ModTime.IsZero() {
+				t.Fatalf(`modification time of kept file isn't set`)
+			}
+		})
+	}
+}
+
+func TestFindStreamCancelled(t *testing.T) {
+	root, _ := makeTree(t, 3, 3)
+	defer os.RemoveAll(root)
+
+	const cancelAfter = 10
+
+	for _, compare := range []bool{false, true} {
+		finder, err := NewFinder(FinderOptions{Workers: 4, Compare: compare})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		scanned := make(chan File)
+		files := make(chan File)
+
+		scanner := NewScanner(ScannerOptions{
+			Workers: 4,
+			Output:  scanned,
+		})
+
+		// Cancel in the middle of the walk while files are still being sent
+		go func() {
+			defer close(files)
+
+			count := 0
+			for f := range scanned {
+				count++
+				if count == cancelAfter {
+					cancel()
+				}
+
+				files <- f
+			}
+		}()
+
+		done := make(chan error)
+
+		go func() {
+			_, err := finder.FindStream(ctx, files)
+			done <- err
+		}()
+
+		scanErr := scanner.AddRoot(ctx, Root{Path: root})
+		close(scanned)
+
+		findErr := <-done
+		cancel()
+
+		if scanErr != context.Canceled {
+			t.Fatalf(`compare %v: got scan error %v, expected %v`, compare, scanErr, context.Canceled)
+		}
+
+		if findErr != context.Canceled {
+			t.Fatalf(`compare %v: got find error %v, expected %v`, compare, findErr, context.Canceled)
+		}
+	}
+}
+
+func TestFindReadErrorsCollected(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []File
+
+	for _, name := range []string{`a`, `b`} {
+		path := filepath.Join(dir, name)
+
+		err = ioutil.WriteFile(path, []byte(`abc`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files = append(files, File{Path: path, Size: 3, INode: uint64(len(files))})
+	}
+
+	// Files which vanished after they were found
+	for i := 0; i < 5; i++ {
+		files = append(files, File{Path: filepath.Join(dir, fmt.Sprintf(`missing%v`, i)), Size: 3})
+	}
+
+	for _, compare := range []bool{false, true} {
+		// Errors are collected when Find returns
+		for i := 0; i < 20; i++ {
+			errs := &ErrorLog{}
+
+			finder, err := NewFinder(FinderOptions{Workers: 4, Compare: compare, Errors: errs})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			groups, err := finder.Find(context.Background(), files)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(groups) != 1 {
+				t.Fatalf(`compare %v: got %v groups, expected 1`, compare, len(groups))
+			}
+
+			if count := errs.Count(OP_READ); count != 5 {
+				t.Fatalf(`compare %v: got %v read errors, expected 5`, compare, count)
+			}
+		}
+	}
+}
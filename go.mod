@@ -1,5 +1,5 @@
 module github.com/raspi/duplikaatti
 
-go 1.15
+go 1.22
 
-require github.com/raspi/dirscanner v0.0.0-20190406194214-7b842ff17ac2
+require github.com/klauspost/compress v1.18.0
@@ -0,0 +1,85 @@
+package duplikaatti
+
+import (
+	"sort"
+)
+
+// DuplicateGroup is a group of files with identical content
+type DuplicateGroup struct {
+	Hash     string // Checksum of the files, identifier of the group when files were compared byte by byte
+	Files    []File // Files, first one is kept
+	KeepRule string // Name of the rule which selected the kept file
+}
+
+// Rule name for files selected by hand
+const KEEP_RULE_MANUAL = `manual`
+
+// SetKeep moves given file first so that it's kept and tells if the group
+// changed. Archive members and files outside the roots can't be kept instead of
+// other files.
+func (g *DuplicateGroup) SetKeep(idx int) (changed bool) {
+	if idx <= 0 || idx >= len(g.Files) || g.Files[idx].IsArchiveMember() || g.Files[idx].Outside {
+		return false
+	}
+
+	keep := g.Files[idx]
+	copy(g.Files[1:idx+1], g.Files[0:idx])
+	g.Files[0] = keep
+	g.KeepRule = KEEP_RULE_MANUAL
+
+	return true
+}
+
+// Keep returns the file which is kept
+func (g DuplicateGroup) Keep() File {
+	return g.Files[0]
+}
+
+// Duplicates returns files which can be acted on, reference files, archive
+// members and files outside the roots are never included
+func (g DuplicateGroup) Duplicates() (files []File) {
+	for _, f := range g.Files[1:] {
+		if !f.removable() {
+			continue
+		}
+
+		files = append(files, f)
+	}
+
+	return files
+}
+
+// GetDuplicateList returns groups of duplicate files. Files in a group are
+// ordered by the keep policy. Groups are ordered by file size, largest first,
+// and then by checksum.
+func GetDuplicateList(m map[Checksum]map[uint64][]File, policy KeepPolicy) (dupes []DuplicateGroup) {
+	for hash, sizeKey := range m {
+		for _, files := range sizeKey {
+			if len(files) < 2 {
+				// Unique file
+				continue
+			}
+
+			selected := make([]File, len(files))
+			copy(selected, files)
+
+			keepRule := policy.Sort(selected)
+
+			dupes = append(dupes, DuplicateGroup{
+				Hash:     hash.String(),
+				Files:    selected,
+				KeepRule: keepRule,
+			})
+		}
+	}
+
+	sort.SliceStable(dupes, func(i, j int) bool {
+		if dupes[i].Files[0].Size != dupes[j].Files[0].Size {
+			return dupes[i].Files[0].Size > dupes[j].Files[0].Size
+		}
+
+		return dupes[i].Hash < dupes[j].Hash
+	})
+
+	return dupes
+}
@@ -0,0 +1,127 @@
+package duplikaatti
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetDuplicateList(t *testing.T) {
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	small := Checksum{1}
+	large := Checksum{2}
+	other := Checksum{3}
+	unique := Checksum{4}
+
+	// Files from several roots, all with the same modification time
+	m := map[Checksum]map[uint64][]File{
+		small: {
+			10: {
+				{Path: `/c/s`, Priority: 253, Size: 10, ModTime: mtime, INode: 1},
+				{Path: `/a/s`, Priority: 255, Size: 10, ModTime: mtime, INode: 2},
+				{Path: `/b/s`, Priority: 254, Size: 10, ModTime: mtime, INode: 3},
+			},
+		},
+		large: {
+			100: {
+				{Path: `/b/l2`, Priority: 254, Size: 100, ModTime: mtime, INode: 4},
+				{Path: `/b/l1`, Priority: 254, Size: 100, ModTime: mtime, INode: 5},
+				{Path: `/ref/l`, Priority: 255, Reference: true, Size: 100, ModTime: mtime, INode: 6},
+			},
+		},
+		other: {
+			100: {
+				{Path: `/c/o`, Priority: 253, Size: 100, ModTime: mtime, INode: 7},
+				{Path: `/a/o`, Priority: 255, Size: 100, ModTime: mtime, INode: 8},
+			},
+		},
+		unique: {
+			100: {
+				{Path: `/a/u`, Priority: 255, Size: 100, ModTime: mtime, INode: 9},
+			},
+		},
+	}
+
+	policy, err := NewRulePolicy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash  Checksum
+		order []string
+		rule  string
+	}{
+		{large, []string{`/ref/l`, `/b/l1`, `/b/l2`}, `reference`},
+		{other, []string{`/a/o`, `/c/o`}, `priority`},
+		{small, []string{`/a/s`, `/b/s`, `/c/s`}, `priority`},
+	}
+
+	// Map iteration order is random, so the result is checked several times
+	for i := 0; i < 10; i++ {
+		groups := GetDuplicateList(m, policy)
+
+		if len(groups) != len(tests) {
+			t.Fatalf(`got %v groups, expected %v`, len(groups), len(tests))
+		}
+
+		for idx, tt := range tests {
+			g := groups[idx]
+
+			if g.Hash != tt.hash.String() {
+				t.Fatalf(`group %v: got hash %v, expected %v`, idx, g.Hash, tt.hash)
+			}
+
+			var order []string
+			for _, f := range g.Files {
+				order = append(order, f.Path)
+			}
+
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Fatalf(`group %v: got order %v, expected %v`, idx, order, tt.order)
+			}
+
+			if g.KeepRule != tt.rule {
+				t.Fatalf(`group %v: got rule %#v, expected %#v`, idx, g.KeepRule, tt.rule)
+			}
+		}
+	}
+
+	// Input must not be reordered
+	if m[small][10][0].Path != `/c/s` {
+		t.Fatalf(`input was modified`)
+	}
+}
+
+func TestDuplicateGroupDuplicates(t *testing.T) {
+	g := DuplicateGroup{
+		Files: []File{
+			{Path: `/a/f`},
+			{Path: `/ref/f`, Reference: true},
+			{Path: `/a/x.zip!/f`, Archive: `/a/x.zip`, Member: `f`},
+			{Path: `/outside/f`, Outside: true},
+			{Path: `/b/f`},
+		},
+	}
+
+	dupes := g.Duplicates()
+	if len(dupes) != 1 || dupes[0].Path != `/b/f` {
+		t.Fatalf(`got %v, expected only /b/f`, dupes)
+	}
+
+	// Archive members and files outside the roots can't be kept
+	for _, idx := range []int{0, 2, 3} {
+		if g.SetKeep(idx) || g.Keep().Path != `/a/f` {
+			t.Fatalf(`%v was kept`, g.Keep().Path)
+		}
+	}
+
+	if !g.SetKeep(4) || g.Keep().Path != `/b/f` || g.KeepRule != KEEP_RULE_MANUAL {
+		t.Fatalf(`got kept file %v with rule %#v`, g.Keep().Path, g.KeepRule)
+	}
+
+	if g.Files[1].Path != `/a/f` {
+		t.Fatalf(`previously kept file should be second, got %v`, g.Files[1].Path)
+	}
+}
@@ -1,11 +1,27 @@
-package main
+package duplikaatti
 
 import (
 	"fmt"
+	"io/ioutil"
+	"log"
 	"math"
 	"os"
 )
 
+const (
+	MEBIBYTE = 1048576
+	GIBIBYTE = 1073741824
+)
+
+// Logger for optional logging, nil discards messages
+func loggerOrDiscard(l *log.Logger) *log.Logger {
+	if l == nil {
+		return log.New(ioutil.Discard, ``, 0)
+	}
+
+	return l
+}
+
 func isPowerOfTwo(n uint64) bool {
 	if n == 0 || n == 1 {
 		return false
@@ -13,8 +29,8 @@ func isPowerOfTwo(n uint64) bool {
 	return (n & (n - 1)) == 0
 }
 
-// Convert 1024 to '1 KiB' etc
-func bytesToHuman(src uint64) string {
+// BytesToHuman converts 1024 to '1 KiB' etc
+func BytesToHuman(src uint64) string {
 	if src < 10 {
 		return fmt.Sprintf("%d B", src)
 	}
@@ -39,9 +55,7 @@ func isDirectory(dir string) (b bool, err error) {
 	fi, err := os.Stat(dir)
 
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, err
-		}
+		return false, err
 	}
 
 	if !fi.IsDir() {
@@ -0,0 +1,275 @@
+package duplikaatti
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeepPolicy selects which one of duplicate files is kept
+type KeepPolicy interface {
+	// Sort sorts files so that the file to be kept is first and returns name
+	// of the rule which decided it. Order must not depend on the original order.
+	Sort(files []File) (ruleName string)
+}
+
+// KeepRule compares two duplicate files. Negative result means that a should
+// be kept rather than b, positive the opposite and zero that the rule can't decide.
+type KeepRule struct {
+	Name    string
+	Compare func(a, b File) int
+}
+
+// RulePolicy is an ordered list of rules, first rule that can decide wins
+type RulePolicy []KeepRule
+
+// DefaultKeepRules are used when no rules are given
+var DefaultKeepRules = []string{`priority`, `oldest`}
+
+// Compare returns the comparison result and index of the rule which decided it
+func (p RulePolicy) Compare(a, b File) (c int, rule int) {
+	for idx, r := range p {
+		c = r.Compare(a, b)
+		if c != 0 {
+			return c, idx
+		}
+	}
+
+	return 0, -1
+}
+
+// Sort sorts files so that the file to be kept is first and returns name of
+// the rule which separated it from the first file which can be removed, or from
+// the next file when none can. Sorting is stable and tie-breaker rules make the
+// order total, so the result doesn't depend on the order in which files were
+// found.
+func (p RulePolicy) Sort(files []File) (ruleName string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		c, _ := p.Compare(files[i], files[j])
+		return c < 0
+	})
+
+	if len(files) < 2 {
+		return ``
+	}
+
+	next := files[1]
+	for _, f := range files[1:] {
+		if f.removable() {
+			next = f
+			break
+		}
+	}
+
+	_, rule := p.Compare(files[0], next)
+	if rule == -1 {
+		return ``
+	}
+
+	return p[rule].Name
+}
+
+// NewRulePolicy creates policy from given rules. Files outside archives,
+// reference files and files inside the roots are always preferred and path and
+// inode are used as last tie-breakers so that the result is always
+// deterministic.
+func NewRulePolicy(rules []string) (p RulePolicy, err error) {
+	if len(rules) == 0 {
+		rules = DefaultKeepRules
+	}
+
+	// Archive members are only reported, so a real file is kept when there is one
+	p = append(p, KeepRule{
+		Name: `not archived`,
+		Compare: func(a, b File) int {
+			return compareBool(!a.IsArchiveMember(), !b.IsArchiveMember())
+		},
+	})
+
+	p = append(p, KeepRule{
+		Name: `reference`,
+		Compare: func(a, b File) int {
+			return compareBool(a.Reference, b.Reference)
+		},
+	})
+
+	// Symbolic link targets outside the roots are only used for matching
+	p = append(p, KeepRule{
+		Name: `inside roots`,
+		Compare: func(a, b File) int {
+			return compareBool(!a.Outside, !b.Outside)
+		},
+	})
+
+	for _, r := range rules {
+		rule, err := ParseKeepRule(r)
+		if err != nil {
+			return nil, err
+		}
+
+		p = append(p, rule)
+	}
+
+	p = append(p,
+		KeepRule{
+			Name: `path order`,
+			Compare: func(a, b File) int {
+				return strings.Compare(a.Path, b.Path)
+			},
+		},
+		KeepRule{
+			Name: `inode order`,
+			Compare: func(a, b File) int {
+				return compareUint64(a.INode, b.INode)
+			},
+		},
+	)
+
+	return p, nil
+}
+
+// ParseKeepRule parses a single rule such as `oldest` or `prefer:^/archive/`
+func ParseKeepRule(s string) (rule KeepRule, err error) {
+	rule.Name = s
+
+	name, arg := s, ``
+	if idx := strings.Index(s, `:`); idx != -1 {
+		name, arg = s[:idx], s[idx+1:]
+	}
+
+	switch name {
+	case `priority`: // Higher priority is kept
+		rule.Compare = func(a, b File) int {
+			return -compareUint64(uint64(a.Priority), uint64(b.Priority))
+		}
+	case `oldest`: // Oldest modification time is kept
+		rule.Compare = func(a, b File) int {
+			return compareInt64(a.ModTime.UnixNano(), b.ModTime.UnixNano())
+		}
+	case `newest`: // Newest modification time is kept
+		rule.Compare = func(a, b File) int {
+			return -compareInt64(a.ModTime.UnixNano(), b.ModTime.UnixNano())
+		}
+	case `shortest-path`:
+		rule.Compare = func(a, b File) int {
+			return compareInt64(int64(len(a.Path)), int64(len(b.Path)))
+		}
+	case `longest-path`:
+		rule.Compare = func(a, b File) int {
+			return -compareInt64(int64(len(a.Path)), int64(len(b.Path)))
+		}
+	case `shallowest`: // Least directories in path is kept
+		rule.Compare = func(a, b File) int {
+			return compareInt64(int64(pathDepth(a.Path)), int64(pathDepth(b.Path)))
+		}
+	case `deepest`: // Most directories in path is kept
+		rule.Compare = func(a, b File) int {
+			return -compareInt64(int64(pathDepth(a.Path)), int64(pathDepth(b.Path)))
+		}
+	case `prefer`, `avoid`: // Path matching regular expression is kept (prefer) or removed (avoid)
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return rule, fmt.Errorf(`invalid regular expression in keep rule %#v: %v`, s, err)
+		}
+
+		sign := 1
+		if name == `avoid` {
+			sign = -1
+		}
+
+		rule.Compare = func(a, b File) int {
+			return sign * compareBool(re.MatchString(a.Path), re.MatchString(b.Path))
+		}
+	case `name`: // File name matching glob pattern is kept
+		_, err := filepath.Match(arg, ``)
+		if err != nil {
+			return rule, fmt.Errorf(`invalid pattern in keep rule %#v: %v`, s, err)
+		}
+
+		rule.Compare = func(a, b File) int {
+			am, _ := filepath.Match(arg, filepath.Base(a.Path))
+			bm, _ := filepath.Match(arg, filepath.Base(b.Path))
+			return compareBool(am, bm)
+		}
+	case `owner`: // File owned by given user name or uid is kept
+		uid, err := lookupUID(arg)
+		if err != nil {
+			return rule, fmt.Errorf(`invalid owner in keep rule %#v: %v`, s, err)
+		}
+
+		rule.Compare = func(a, b File) int {
+			return compareBool(a.Owner == uid, b.Owner == uid)
+		}
+	default:
+		return rule, fmt.Errorf(`unknown keep rule: %#v`, s)
+	}
+
+	return rule, nil
+}
+
+// lookupUID returns uid of given user name or numeric uid
+func lookupUID(s string) (uint32, error) {
+	uid, err := strconv.ParseUint(s, 10, 32)
+	if err == nil {
+		return uint32(uid), nil
+	}
+
+	u, err := user.Lookup(s)
+	if err != nil {
+		return 0, err
+	}
+
+	uid, err = strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf(`user %v has non-numeric uid %v`, s, u.Uid)
+	}
+
+	return uint32(uid), nil
+}
+
+// Number of directories in path
+func pathDepth(path string) int {
+	return strings.Count(filepath.Clean(path), string(os.PathSeparator))
+}
+
+// true is preferred
+func compareBool(a, b bool) int {
+	if a == b {
+		return 0
+	}
+
+	if a {
+		return -1
+	}
+
+	return 1
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	}
+
+	if a > b {
+		return 1
+	}
+
+	return 0
+}
+
+func compareUint64(a, b uint64) int {
+	if a < b {
+		return -1
+	}
+
+	if a > b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,206 @@
+package duplikaatti
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRulePolicySort(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mid := old.Add(time.Hour)
+	recent := old.Add(2 * time.Hour)
+
+	tests := []struct {
+		name  string
+		rules []string
+		files []File
+		order []string // Expected paths, kept file first
+		rule  string   // Expected name of the deciding rule
+	}{
+		{
+			name:  `default rules prefer priority`,
+			rules: nil,
+			files: []File{
+				{Path: `/b/f`, Priority: 254, ModTime: old},
+				{Path: `/a/f`, Priority: 255, ModTime: recent},
+				{Path: `/c/f`, Priority: 253, ModTime: old},
+			},
+			order: []string{`/a/f`, `/b/f`, `/c/f`},
+			rule:  `priority`,
+		},
+		{
+			name:  `default rules use age within root`,
+			rules: nil,
+			files: []File{
+				{Path: `/a/new`, Priority: 255, ModTime: recent},
+				{Path: `/a/old`, Priority: 255, ModTime: old},
+				{Path: `/b/older`, Priority: 254, ModTime: old.Add(-time.Hour)},
+			},
+			order: []string{`/a/old`, `/a/new`, `/b/older`},
+			rule:  `oldest`,
+		},
+		{
+			name:  `tied mtimes fall back to path order`,
+			rules: nil,
+			files: []File{
+				{Path: `/a/z`, Priority: 255, ModTime: mid, INode: 1},
+				{Path: `/a/m`, Priority: 255, ModTime: mid, INode: 2},
+				{Path: `/a/b`, Priority: 255, ModTime: mid, INode: 3},
+			},
+			order: []string{`/a/b`, `/a/m`, `/a/z`},
+			rule:  `path order`,
+		},
+		{
+			name:  `tied paths fall back to inode order`,
+			rules: nil,
+			files: []File{
+				{Path: `/a/f`, Priority: 255, ModTime: mid, INode: 9},
+				{Path: `/a/f`, Priority: 255, ModTime: mid, INode: 3},
+			},
+			order: []string{`/a/f`, `/a/f`},
+			rule:  `inode order`,
+		},
+		{
+			name:  `reference outranks priority`,
+			rules: nil,
+			files: []File{
+				{Path: `/a/f`, Priority: 255, ModTime: old},
+				{Path: `/ref/f`, Priority: 1, Reference: true, ModTime: recent},
+			},
+			order: []string{`/ref/f`, `/a/f`},
+			rule:  `reference`,
+		},
+		{
+			name:  `rule is reported against the first removable file`,
+			rules: nil,
+			files: []File{
+				{Path: `/ref/new`, Priority: 1, Reference: true, ModTime: recent},
+				{Path: `/ref/old`, Priority: 1, Reference: true, ModTime: mid},
+				{Path: `/a/f`, Priority: 255, ModTime: old},
+			},
+			order: []string{`/ref/old`, `/ref/new`, `/a/f`},
+			rule:  `reference`,
+		},
+		{
+			name:  `symlink target outside the roots is never kept before a file in the roots`,
+			rules: nil,
+			files: []File{
+				{Path: `/outside/f`, Priority: 255, Outside: true, ModTime: old},
+				{Path: `/a/f`, Priority: 1, ModTime: recent},
+			},
+			order: []string{`/a/f`, `/outside/f`},
+			rule:  `inside roots`,
+		},
+		{
+			name:  `archive member is never kept before a file`,
+			rules: nil,
+			files: []File{
+				{Path: `/a/x.zip!/f`, Priority: 255, ModTime: old, Archive: `/a/x.zip`, Member: `f`},
+				{Path: `/b/f`, Priority: 1, ModTime: recent},
+			},
+			order: []string{`/b/f`, `/a/x.zip!/f`},
+			rule:  `not archived`,
+		},
+		{
+			name:  `newest ignores priority when given first`,
+			rules: []string{`newest`, `priority`},
+			files: []File{
+				{Path: `/a/f`, Priority: 255, ModTime: old},
+				{Path: `/b/f`, Priority: 254, ModTime: recent},
+				{Path: `/c/f`, Priority: 253, ModTime: recent},
+			},
+			order: []string{`/b/f`, `/c/f`, `/a/f`},
+			rule:  `priority`,
+		},
+		{
+			name:  `prefer and avoid`,
+			rules: []string{`avoid:/tmp/`, `prefer:/photos/`},
+			files: []File{
+				{Path: `/tmp/photos/f`, ModTime: old},
+				{Path: `/x/f`, ModTime: old},
+				{Path: `/y/photos/f`, ModTime: old},
+			},
+			order: []string{`/y/photos/f`, `/x/f`, `/tmp/photos/f`},
+			rule:  `prefer:/photos/`,
+		},
+		{
+			name:  `shallowest and name`,
+			rules: []string{`shallowest`, `name:*.jpg`},
+			files: []File{
+				{Path: `/a/b/c.jpg`},
+				{Path: `/a/c.jpeg`},
+				{Path: `/b/c.jpg`},
+			},
+			order: []string{`/b/c.jpg`, `/a/c.jpeg`, `/a/b/c.jpg`},
+			rule:  `name:*.jpg`,
+		},
+		{
+			name:  `owner by uid`,
+			rules: []string{`owner:1000`},
+			files: []File{
+				{Path: `/a/f`, Owner: 0},
+				{Path: `/b/f`, Owner: 1000},
+			},
+			order: []string{`/b/f`, `/a/f`},
+			rule:  `owner:1000`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewRulePolicy(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Result must not depend on the order files were found in
+			rnd := rand.New(rand.NewSource(1))
+
+			for i := 0; i < 10; i++ {
+				files := make([]File, len(tt.files))
+				copy(files, tt.files)
+				rnd.Shuffle(len(files), func(i, j int) {
+					files[i], files[j] = files[j], files[i]
+				})
+
+				rule := p.Sort(files)
+
+				var order []string
+				for _, f := range files {
+					order = append(order, f.Path)
+				}
+
+				if !reflect.DeepEqual(order, tt.order) {
+					t.Fatalf(`got order %v, expected %v`, order, tt.order)
+				}
+
+				if rule != tt.rule {
+					t.Fatalf(`got rule %#v, expected %#v`, rule, tt.rule)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRulePolicyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+	}{
+		{`unknown rule`, []string{`biggest`}},
+		{`invalid regexp`, []string{`prefer:(`}},
+		{`invalid pattern`, []string{`name:[`}},
+		{`valid rule before invalid`, []string{`oldest`, `avoid:[`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRulePolicy(tt.rules)
+			if err == nil {
+				t.Fatalf(`expected error for %v`, tt.rules)
+			}
+		})
+	}
+}
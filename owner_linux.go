@@ -0,0 +1,16 @@
+package duplikaatti
+
+import (
+	"os"
+	"syscall"
+)
+
+// getOwner returns uid of the file owner
+func getOwner(fi os.FileInfo) uint32 {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+
+	return stat.Uid
+}
@@ -0,0 +1,10 @@
+package duplikaatti
+
+import (
+	"os"
+)
+
+// getOwner returns uid of the file owner, files don't have numeric owners on windows
+func getOwner(fi os.FileInfo) uint32 {
+	return 0
+}
@@ -0,0 +1,380 @@
+package duplikaatti
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress output formats
+const (
+	PROGRESS_HUMAN = `human` // Log lines with a progress bar
+	PROGRESS_JSON  = `json`  // Newline delimited JSON events
+	PROGRESS_NONE  = `none`  // No progress output
+)
+
+// Progress stages
+const (
+	STAGE_SCAN        = `scan`
+	STAGE_FIRST_BYTES = `first-bytes`
+	STAGE_LAST_BYTES  = `last-bytes`
+	STAGE_HASH        = `hash`
+	STAGE_COMPARE     = `compare`
+	STAGE_REMOVE      = `remove`
+)
+
+// Human readable stage names
+var stageNames = map[string]string{
+	STAGE_SCAN:        `Scanning`,
+	STAGE_FIRST_BYTES: `First bytes`,
+	STAGE_LAST_BYTES:  `Last bytes`,
+	STAGE_HASH:        `Hashing`,
+	STAGE_COMPARE:     `Comparing`,
+	STAGE_REMOVE:      `Removing`,
+}
+
+// Event emitted in JSON format
+type progressEvent struct {
+	Time           time.Time `json:"time"`
+	Event          string    `json:"event"` // stage_start, progress, stage_finish or stats
+	Stage          string    `json:"stage,omitempty"`
+	Files          uint64    `json:"files"`
+	Bytes          uint64    `json:"bytes"`
+	TotalFiles     uint64    `json:"total_files,omitempty"` // Zero when not known
+	TotalBytes     uint64    `json:"total_bytes,omitempty"` // Zero when not known
+	FilesPerSecond float64   `json:"files_per_second"`
+	BytesPerSecond float64   `json:"bytes_per_second"`
+	ETASeconds     float64   `json:"eta_seconds,omitempty"`
+	StageSeconds   float64   `json:"stage_seconds"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+	Current        string    `json:"current,omitempty"`
+	MemoryBytes    uint64    `json:"memory_bytes,omitempty"`     // Heap in use, only in stats
+	MemorySysBytes uint64    `json:"memory_sys_bytes,omitempty"` // Memory obtained from the OS, only in stats
+}
+
+// Counters of a stage
+type stageProgress struct {
+	name       string
+	start      time.Time
+	totalFiles uint64
+	totalBytes uint64
+	files      uint64
+	bytes      uint64
+	current    string
+}
+
+// Progress tracks files and bytes processed in each running stage and reports
+// them once a second. Stages can run at the same time. It's safe for
+// concurrent use. All methods can be called on a nil *Progress, so progress
+// reporting is optional.
+type Progress struct {
+	mu        sync.Mutex
+	format    string
+	out       io.Writer   // Output for JSON events
+	logger    *log.Logger // Output for human readable progress and errors
+	startTime time.Time
+	stages    []*stageProgress // Running stages in start order
+	stop      chan bool
+	wg        sync.WaitGroup
+}
+
+// NewProgress creates progress reporter which writes human readable progress
+// lines or JSON events to out
+func NewProgress(format string, out io.Writer, startTime time.Time) (p *Progress, err error) {
+	switch format {
+	case PROGRESS_HUMAN, PROGRESS_JSON, PROGRESS_NONE:
+	default:
+		return nil, fmt.Errorf(`invalid progress format: %#v`, format)
+	}
+
+	p = &Progress{
+		format:    format,
+		out:       out,
+		logger:    log.New(out, ``, log.LstdFlags),
+		startTime: startTime,
+		stop:      make(chan bool),
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p, nil
+}
+
+// Report progress once a second
+func (p *Progress) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			for _, st := range p.stages {
+				p.emit(`progress`, st)
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Close stops reporting
+func (p *Progress) Close() {
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// Find running stage, must be called with lock held
+func (p *Progress) stage(name string) *stageProgress {
+	for _, st := range p.stages {
+		if st.name == name {
+			return st
+		}
+	}
+
+	return nil
+}
+
+// StartStage starts counting a new stage. Totals are zero when not known.
+func (p *Progress) StartStage(stage string, totalFiles uint64, totalBytes uint64) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := &stageProgress{
+		name:       stage,
+		start:      time.Now(),
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+	}
+
+	p.stages = append(p.stages, st)
+	p.emit(`stage_start`, st)
+}
+
+// FinishStage reports final counters of given stage
+func (p *Progress) FinishStage(stage string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for idx, st := range p.stages {
+		if st.name != stage {
+			continue
+		}
+
+		st.current = ``
+		p.emit(`stage_finish`, st)
+		p.stages = append(p.stages[:idx], p.stages[idx+1:]...)
+		return
+	}
+}
+
+// AddTotal grows totals of a stage whose input is still being generated by
+// an earlier stage
+func (p *Progress) AddTotal(stage string, files uint64, bytes uint64) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := p.stage(stage)
+	if st == nil {
+		return
+	}
+
+	st.totalFiles += files
+	st.totalBytes += bytes
+}
+
+// Add processed files and bytes to given stage
+func (p *Progress) Add(stage string, files uint64, bytes uint64) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := p.stage(stage)
+	if st == nil {
+		return
+	}
+
+	st.files += files
+	st.bytes += bytes
+}
+
+// SetCurrent sets file or directory which is currently processed in given stage
+func (p *Progress) SetCurrent(stage string, path string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := p.stage(stage)
+	if st == nil {
+		return
+	}
+
+	st.current = path
+}
+
+// Stats reports how many files are still candidates after a stage and how
+// much memory is used
+func (p *Progress) Stats(files uint64, bytes uint64) {
+	if p == nil {
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.format {
+	case PROGRESS_HUMAN:
+		p.logger.Printf(`%v files %v, memory %v (%v from OS)`, files, BytesToHuman(bytes), BytesToHuman(mem.HeapAlloc), BytesToHuman(mem.Sys))
+	case PROGRESS_JSON:
+		p.write(progressEvent{
+			Time:           time.Now(),
+			Event:          `stats`,
+			Files:          files,
+			Bytes:          bytes,
+			ElapsedSeconds: time.Since(p.startTime).Seconds(),
+			MemoryBytes:    mem.HeapAlloc,
+			MemorySysBytes: mem.Sys,
+		})
+	}
+}
+
+// Build event from stage state, must be called with lock held
+func (p *Progress) event(name string, st *stageProgress) (e progressEvent) {
+	now := time.Now()
+	stageTime := now.Sub(st.start).Seconds()
+
+	e = progressEvent{
+		Time:           now,
+		Event:          name,
+		Stage:          st.name,
+		Files:          st.files,
+		Bytes:          st.bytes,
+		TotalFiles:     st.totalFiles,
+		TotalBytes:     st.totalBytes,
+		StageSeconds:   stageTime,
+		ElapsedSeconds: now.Sub(p.startTime).Seconds(),
+		Current:        st.current,
+	}
+
+	if stageTime > 0 {
+		e.FilesPerSecond = float64(st.files) / stageTime
+		e.BytesPerSecond = float64(st.bytes) / stageTime
+	}
+
+	// Estimate from bytes when known as file sizes vary a lot
+	if st.totalBytes > 0 && e.BytesPerSecond > 0 && st.bytes <= st.totalBytes {
+		e.ETASeconds = float64(st.totalBytes-st.bytes) / e.BytesPerSecond
+	} else if st.totalFiles > 0 && e.FilesPerSecond > 0 && st.files <= st.totalFiles {
+		e.ETASeconds = float64(st.totalFiles-st.files) / e.FilesPerSecond
+	}
+
+	return e
+}
+
+// Output event of a stage, must be called with lock held
+func (p *Progress) emit(name string, st *stageProgress) {
+	switch p.format {
+	case PROGRESS_HUMAN:
+		// Stage changes are logged by the caller
+		if name == `progress` {
+			p.logger.Print(formatProgress(p.event(name, st)))
+		}
+	case PROGRESS_JSON:
+		p.write(p.event(name, st))
+	}
+}
+
+func (p *Progress) write(e progressEvent) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		p.logger.Printf(`progress: %v`, err)
+		return
+	}
+
+	_, err = p.out.Write(append(b, '\n'))
+	if err != nil {
+		p.logger.Printf(`progress: %v`, err)
+	}
+}
+
+// Format event as a human readable line with a progress bar
+func formatProgress(e progressEvent) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, `[%v] %v`, time.Duration(e.ElapsedSeconds*float64(time.Second)).Truncate(time.Second), stageNames[e.Stage])
+
+	done, total := e.Files, e.TotalFiles
+	if e.TotalBytes > 0 {
+		done, total = e.Bytes, e.TotalBytes
+	}
+
+	if total > 0 {
+		const width = 20
+
+		ratio := float64(done) / float64(total)
+		if ratio > 1 {
+			ratio = 1
+		}
+
+		filled := int(ratio * width)
+		fmt.Fprintf(&sb, ` [%v%v] %.1f%%`, strings.Repeat(`=`, filled), strings.Repeat(` `, width-filled), ratio*100)
+	}
+
+	if e.TotalFiles > 0 {
+		fmt.Fprintf(&sb, ` %v/%v files`, e.Files, e.TotalFiles)
+	} else {
+		fmt.Fprintf(&sb, ` %v files`, e.Files)
+	}
+
+	if e.TotalBytes > 0 {
+		fmt.Fprintf(&sb, ` %v/%v`, BytesToHuman(e.Bytes), BytesToHuman(e.TotalBytes))
+		fmt.Fprintf(&sb, ` %v/s`, BytesToHuman(uint64(e.BytesPerSecond)))
+	} else {
+		fmt.Fprintf(&sb, ` %v`, BytesToHuman(e.Bytes))
+		fmt.Fprintf(&sb, ` %.0f files/s`, e.FilesPerSecond)
+	}
+
+	if e.ETASeconds > 0 {
+		fmt.Fprintf(&sb, ` ETA %v`, time.Duration(e.ETASeconds*float64(time.Second)).Truncate(time.Second))
+	}
+
+	if e.Current != `` {
+		fmt.Fprintf(&sb, ` %v`, e.Current)
+	}
+
+	return sb.String()
+}
@@ -1,178 +1,423 @@
-package main
+package duplikaatti
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
 	"log"
-	"time"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 )
 
-type DupeScanner struct {
-	files       []fileInfo
-	ticker      *time.Ticker
-	workerCount int
-	startTime   *time.Time
-}
+func getFilterFunc(followSymlinks bool) fileFilterFunc {
+	return func(info fileInformation) bool {
+		// Link target is checked when the link is resolved
+		if followSymlinks && info.Mode&os.ModeSymlink != 0 {
+			return true
+		}
+
+		if info.Size == 0 {
+			return false
+		}
+
+		if info.Mode&os.ModeType != 0 {
+			return false
+		}
 
-func New(ticker *time.Ticker, startTime *time.Time, workerCount int) DupeScanner {
-	d := DupeScanner{
-		files:       []fileInfo{},
-		ticker:      ticker,
-		workerCount: workerCount,
-		startTime:   startTime,
+		return true
 	}
+}
 
-	return d
+// Resolved target of a symbolic link which is added after the given roots
+type symlinkTarget struct {
+	Source Source // Source of the directory where the link was found
+	Path   string // Resolved path of the link target
 }
 
-func (ds *DupeScanner) GetFileCount() (l int, ts uint64) {
-	for _, f := range ds.files {
-		ts += uint64(f.Size)
-	}
+// ScannerOptions are options for NewScanner
+type ScannerOptions struct {
+	Workers        int         // Amount of directory scanning workers, defaults to two per CPU
+	FollowSymlinks bool        // Follow symbolic links to files and directories, targets are scanned by ScanSymlinks which must be called after the roots
+	Progress       *Progress   // Optional progress reporting
+	Errors         *ErrorLog   // Optional collection of errors
+	Archives       bool        // Add files inside zip and tar archives as report-only archive members
+	Logger         *log.Logger // Optional, progress messages are logged here
+
+	// Optional, listed directories and their files are recorded here for
+	// finding identical directory trees
+	Directories *DirectoryTree
+
+	// Files are sent here as they're found instead of collecting them for
+	// Files(), for example to Finder.FindStream. Caller closes the channel
+	// after scanning.
+	Output chan<- File
+}
 
-	return len(ds.files), ts
+// Scanner generates the file list from given directories and files
+type Scanner struct {
+	files          []File
+	output         chan<- File
+	errors         *ErrorLog
+	logger         *log.Logger
+	progress       *Progress
+	workerCount    int
+	followSymlinks bool
+	archives       bool
+	directories    *DirectoryTree
+	filterFunc     fileFilterFunc
+	seenFiles      map[fileID]struct{} // look-up table for device and inode of listed files
+	seenDirs       map[fileID]bool     // directories already scanned, used for symlink loop detection
+	symlinkDirs    []symlinkTarget     // directories waiting to be scanned
+	symlinkFiles   []symlinkTarget     // links to files waiting to be resolved
 }
 
-// RemoveFileOrphans removes all files that do not share file sizes
-func (ds *DupeScanner) RemoveFileOrphans() {
-	fileSizeCounts := map[uint64]uint64{}
+func NewScanner(opts ScannerOptions) *Scanner {
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU() * 2
+	}
 
-	for _, f := range ds.files {
-		fileSizeCounts[f.Size]++
+	return &Scanner{
+		output:         opts.Output,
+		errors:         opts.Errors,
+		logger:         loggerOrDiscard(opts.Logger),
+		progress:       opts.Progress,
+		workerCount:    opts.Workers,
+		followSymlinks: opts.FollowSymlinks,
+		archives:       opts.Archives,
+		directories:    opts.Directories,
+		filterFunc:     getFilterFunc(opts.FollowSymlinks),
+		seenFiles:      map[fileID]struct{}{},
+		seenDirs:       map[fileID]bool{},
 	}
+}
 
-	fileSizeHasDupes := map[uint64]bool{}
+// Files returns files found so far. It's empty when ScannerOptions.Output is used.
+func (l *Scanner) Files() []File {
+	return l.files
+}
+
+// ScanDirectory adds files from given directory recursively
+func (l *Scanner) ScanDirectory(ctx context.Context, dir string, src Source) (err error) {
+	err = walkDirectory(ctx, dir, l.workerCount, l.filterFunc, func(listing dirListing) {
+		l.progress.SetCurrent(STAGE_SCAN, listing.Dir)
+
+		for _, e := range listing.Errors {
+			l.errors.Add(OP_SCAN, ``, e)
+		}
+
+		if l.followSymlinks {
+			l.markVisited(listing.Dir)
+		}
+
+		l.directories.addDirectory(listing.Dir)
+
+		depth := directoryDepth(dir, listing.Dir)
 
-	for fileSize, fileCount := range fileSizeCounts {
-		if fileCount > 1 {
-			fileSizeHasDupes[fileSize] = true
+		for _, res := range listing.Files {
+			res.Depth = depth
+			l.addResult(src, res)
 		}
+	})
+	if err != nil {
+		return err
+	}
+
+	l.logger.Printf(`got all files`)
+
+	return nil
+}
+
+// Depth of files in a directory listed under root, files directly in root have depth 1
+func directoryDepth(root string, dir string) uint32 {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == `.` {
+		return 1
 	}
 
-	fileSizeCounts = nil
+	return uint32(strings.Count(rel, string(filepath.Separator))) + 2
+}
 
-	var newFiles []fileInfo
+// ScanSymlinks resolves symbolic links found while scanning and must be called
+// after all roots are added. Targets which were already listed through the
+// roots are skipped, so directories are only listed once and link loops
+// terminate. Files and directories only reachable through a link are outside
+// the roots, they're only used for matching and never removed.
+func (l *Scanner) ScanSymlinks(ctx context.Context) (err error) {
+	for len(l.symlinkFiles) > 0 || len(l.symlinkDirs) > 0 {
+		for len(l.symlinkFiles) > 0 {
+			f := l.symlinkFiles[0]
+			l.symlinkFiles = l.symlinkFiles[1:]
+			l.addSymlinkTarget(f)
+		}
 
-	for _, f := range ds.files {
-		_, ok := fileSizeHasDupes[f.Size]
+		if len(l.symlinkDirs) == 0 {
+			break
+		}
+
+		d := l.symlinkDirs[0]
+		l.symlinkDirs = l.symlinkDirs[1:]
+
+		id, err := getFileID(d.Path)
+		if err != nil {
+			l.errors.Add(OP_SCAN, d.Path, err)
+			continue
+		}
 
-		if !ok {
+		if l.seenDirs[id] {
 			continue
 		}
 
-		newFiles = append(newFiles, f)
+		l.logger.Printf(`Following symlink to %v`, d.Path)
+		l.markVisited(d.Path)
+
+		src := d.Source
+		src.Outside = true
+
+		err = l.ScanDirectory(ctx, d.Path, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddRoot adds directory or file
+func (l *Scanner) AddRoot(ctx context.Context, root Root) (err error) {
+	isDir, _ := isDirectory(root.Path)
+
+	if isDir {
+		return l.ScanDirectory(ctx, root.Path, root.Source)
+	}
+
+	l.AddPath(root.Path, root.Source)
+
+	return nil
+}
+
+// AddPath adds a single file given as an argument or in a file list
+func (l *Scanner) AddPath(path string, src Source) {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		l.errors.Add(OP_SCAN, path, err)
+		return
+	}
 
+	if fi.IsDir() {
+		l.logger.Printf(`skipping directory %v`, path)
+		return
 	}
 
-	fileSizeHasDupes = nil
+	id, err := getFileID(path)
+	if err != nil {
+		l.errors.Add(OP_SCAN, path, err)
+		return
+	}
+
+	res := fileInformation{
+		Path:       path,
+		Size:       uint64(fi.Size()),
+		Identifier: id.INode,
+		Device:     id.Device,
+		Mode:       fi.Mode(),
+	}
+
+	if !l.filterFunc(res) {
+		return
+	}
 
-	ds.files = newFiles
+	l.addResult(src, res)
 }
 
-func (ds *DupeScanner) startWorker(readSize int64, rt ReadOperationType) hasherWorker {
-	worker := NewBytesWorker(ds.ticker, ds.startTime, ds.workerCount, readSize, rt)
+// AddFileList adds files from a newline or NUL separated list such as output of `find -print0`
+func (l *Scanner) AddFileList(ctx context.Context, r io.Reader, src Source) (err error) {
+	br := bufio.NewReaderSize(r, 65536)
 
-	worker.Wg.Add(1)
+	sep := byte('\n')
 
-	fileCount, _ := ds.GetFileCount()
+	// Detect separator from the beginning of the list
+	head, err := br.Peek(br.Size())
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
 
-	go func(w *hasherWorker) {
-		for _, file := range ds.files {
-			fileCount--
-			w.Wg.Add(1)
-			w.Jobs <- file
-		}
+	if bytes.IndexByte(head, 0) != -1 {
+		sep = 0
+	}
 
-		w.Wg.Done()
-	}(&worker) // /func
+	fileCount := 0
+
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-	go func(w *hasherWorker) {
-		for e := range w.Errors {
-			log.Printf(`error: %v`, e)
+		line, err := br.ReadString(sep)
+		if err != nil && err != io.EOF {
+			return err
 		}
-	}(&worker)
 
-	go func(w *hasherWorker) {
-		// Wait jobs to finish
-		w.Wg.Wait()
+		path := strings.TrimSuffix(line, string(sep))
+		if sep == '\n' {
+			path = strings.TrimSuffix(path, "\r")
+		}
 
-		for {
-			if len(w.Results) == 0 && len(w.Jobs) == 0 {
-				break
-			}
+		if path != `` {
+			fileCount++
+			l.AddPath(path, src)
+		}
 
-			time.Sleep(time.Millisecond * 10)
+		if err == io.EOF {
+			break
 		}
+	}
 
-		close(w.Jobs)
-		close(w.Results)
-		close(w.Errors)
-	}(&worker) // /func
+	l.logger.Printf(`got %v files from file list`, fileCount)
 
-	return worker
+	return nil
 }
 
-func (ds *DupeScanner) RemoveBasedOnBytes(readSize int64, rt ReadOperationType) {
-	fbw := ds.startWorker(readSize, rt)
+// Add file or resolve symbolic link from scan result
+func (l *Scanner) addResult(src Source, res fileInformation) {
+	if res.Mode&os.ModeSymlink != 0 {
+		l.addSymlink(src, res)
+		return
+	}
+
+	l.addFile(newFile(src, res))
+}
 
-	hashMap := map[string][]uint64{}
-	for res := range fbw.Results {
-		hashMap[res.Hash] = append(hashMap[res.Hash], res.Info.INode)
+// Add file to the list if it's not already listed
+func (l *Scanner) addFile(info File) {
+	id := fileID{Device: info.Device, INode: info.INode}
+	if _, ok := l.seenFiles[id]; ok {
+		return
 	}
 
-	keepInodes := make(map[uint64]bool)
-	for _, inodes := range hashMap {
-		if len(inodes) > 1 {
-			for _, inode := range inodes {
-				keepInodes[inode] = true
-			}
-		}
+	l.seenFiles[id] = struct{}{}
+	l.emit(info)
+
+	if l.archives && archiveFormat(info.Path) != archiveNone {
+		l.addArchiveMembers(info)
 	}
+}
 
-	hashMap = nil
+// Add regular files inside an archive. Members share the inode of the archive
+// so they're not checked against seen inodes.
+func (l *Scanner) addArchiveMembers(archive File) {
+	members, err := listArchive(archive.Path)
+	if err != nil {
+		// Members listed before the error are still added
+		l.errors.Add(OP_SCAN, archive.Path, err)
+	}
 
-	var newFiles []fileInfo
-	for _, file := range ds.files {
-		_, ok := keepInodes[file.INode]
-		if !ok {
+	for _, m := range members {
+		if m.Size == 0 {
 			continue
 		}
 
-		newFiles = append(newFiles, file)
+		l.emit(File{
+			Priority:  archive.Priority,
+			Reference: archive.Reference,
+			Path:      archive.Path + ARCHIVE_SEPARATOR + m.Name,
+			INode:     archive.INode,
+			Device:    archive.Device,
+			Depth:     archive.Depth,
+			Size:      m.Size,
+			ModTime:   m.ModTime,
+			Archive:   archive.Path,
+			Member:    m.Name,
+		})
 	}
-	keepInodes = nil
+}
 
-	ds.files = newFiles
+// Pass file forward
+func (l *Scanner) emit(info File) {
+	l.progress.Add(STAGE_SCAN, 1, info.Size)
+	l.directories.addFile(info)
 
-	ds.RemoveFileOrphans()
+	if l.output != nil {
+		l.output <- info
+		return
+	}
 
+	l.files = append(l.files, info)
 }
 
-// Hash whole files
-func (ds *DupeScanner) HashDuplicates(readSize int64) (m map[string]map[uint64][]fileInfo) {
-	m = make(map[string]map[uint64][]fileInfo)
+// Resolve symbolic link. Both files and directories are queued for
+// ScanSymlinks so that targets inside the roots are listed with their own
+// path and source first.
+func (l *Scanner) addSymlink(src Source, res fileInformation) {
+	target, err := filepath.EvalSymlinks(res.Path)
+	if err != nil {
+		l.errors.Add(OP_SCAN, res.Path, err)
+		return
+	}
 
-	fbw := ds.startWorker(readSize, READ_WHOLE)
+	fi, err := os.Stat(target)
+	if err != nil {
+		l.errors.Add(OP_SCAN, target, err)
+		return
+	}
 
-	for res := range fbw.Results {
-		if m[res.Hash] == nil {
-			m[res.Hash] = make(map[uint64][]fileInfo)
-		}
-		m[res.Hash][res.Info.Size] = append(m[res.Hash][res.Info.Size], res.Info)
+	if fi.IsDir() {
+		l.symlinkDirs = append(l.symlinkDirs, symlinkTarget{
+			Source: src,
+			Path:   target,
+		})
+		return
 	}
 
-	return m
+	if !fi.Mode().IsRegular() || fi.Size() == 0 {
+		return
+	}
 
+	l.symlinkFiles = append(l.symlinkFiles, symlinkTarget{
+		Source: src,
+		Path:   target,
+	})
 }
 
-func (ds *DupeScanner) Reset() {
-	ds.files = nil
-}
+// Add file found behind a symbolic link unless it was already listed. Only the
+// link points to it so it's outside the roots and only used for matching.
+func (l *Scanner) addSymlinkTarget(t symlinkTarget) {
+	fi, err := os.Stat(t.Path)
+	if err != nil {
+		l.errors.Add(OP_SCAN, t.Path, err)
+		return
+	}
+
+	id, err := getFileID(t.Path)
+	if err != nil {
+		l.errors.Add(OP_SCAN, t.Path, err)
+		return
+	}
 
-func (ds *DupeScanner) ReportStats() {
-	fileCount, totalSize := ds.GetFileCount()
-	log.Printf(`%v files %v`, fileCount, bytesToHuman(totalSize))
+	if _, ok := l.seenFiles[id]; ok {
+		return
+	}
+
+	l.addFile(File{
+		Priority: t.Source.Priority,
+		Outside:  true,
+		Path:     t.Path,
+		INode:    id.INode,
+		Device:   id.Device,
+		Depth:    1,
+		Size:     uint64(fi.Size()),
+	})
 }
 
-func (ds *DupeScanner) AddFile(info fileInfo) (err error) {
-	ds.files = append(ds.files, info)
-	return nil
+// Mark directory as scanned
+func (l *Scanner) markVisited(dir string) {
+	id, err := getFileID(dir)
+	if err != nil {
+		l.errors.Add(OP_SCAN, dir, err)
+		return
+	}
+
+	l.seenDirs[id] = true
 }
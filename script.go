@@ -0,0 +1,117 @@
+package duplikaatti
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Commands written by ScriptAction
+const (
+	SCRIPT_REMOVE  = `rm`      // Remove duplicates
+	SCRIPT_LINK    = `ln`      // Replace duplicates with hard links to the kept file
+	SCRIPT_REFLINK = `reflink` // Replace duplicates with copy-on-write copies of the kept file (cp --reflink)
+)
+
+// Suffix of temporary file which replaces a duplicate in scripts
+const SCRIPT_TEMP_SUFFIX = `.duplikaatti-tmp`
+
+// ScriptAction writes a POSIX shell script of commands instead of touching
+// the files. Duplicates of each kept file are handled only when the kept file
+// still exists when the script is run. Links are created with a temporary
+// name and moved over the duplicate, so a failed command leaves the duplicate
+// in place. Close must be called after the last file.
+type ScriptAction struct {
+	w       *bufio.Writer
+	command string
+	keep    string // Kept file of the open block, empty when there's none
+}
+
+// NewScriptAction writes the beginning of the script to w
+func NewScriptAction(w io.Writer, command string) (a *ScriptAction, err error) {
+	switch command {
+	case SCRIPT_REMOVE, SCRIPT_LINK, SCRIPT_REFLINK:
+	default:
+		return nil, fmt.Errorf(`unknown script command %#v, expected %v, %v or %v`, command, SCRIPT_REMOVE, SCRIPT_LINK, SCRIPT_REFLINK)
+	}
+
+	a = &ScriptAction{
+		w:       bufio.NewWriter(w),
+		command: command,
+	}
+
+	_, err = fmt.Fprintf(a.w, "#!/bin/sh\n# Generated by duplikaatti %v\n# Review before running.\nset -u\n", time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *ScriptAction) Apply(keep File, duplicate File) (err error) {
+	if keep.IsArchiveMember() || duplicate.IsArchiveMember() {
+		return fmt.Errorf(`%v: archive members are never removed and are not kept instead of files`, duplicate.Path)
+	}
+
+	if keep.Path != a.keep {
+		err = a.closeBlock()
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(a.w, "\n# Keep %v\nif [ -f %v ]; then\n", commentString(keep.Path), shellQuote(keep.Path))
+		if err != nil {
+			return err
+		}
+
+		a.keep = keep.Path
+	}
+
+	k, d := shellQuote(keep.Path), shellQuote(duplicate.Path)
+	tmp := shellQuote(duplicate.Path + SCRIPT_TEMP_SUFFIX)
+
+	switch a.command {
+	case SCRIPT_LINK:
+		_, err = fmt.Fprintf(a.w, "\tln -- %v %v && mv -f -- %v %v\n", k, tmp, tmp, d)
+	case SCRIPT_REFLINK:
+		_, err = fmt.Fprintf(a.w, "\tcp --reflink=always -p -- %v %v && mv -f -- %v %v\n", k, tmp, tmp, d)
+	default:
+		_, err = fmt.Fprintf(a.w, "\trm -- %v\n", d)
+	}
+
+	return err
+}
+
+// Close ends the script and flushes it to the writer
+func (a *ScriptAction) Close() error {
+	err := a.closeBlock()
+	if err != nil {
+		return err
+	}
+
+	return a.w.Flush()
+}
+
+func (a *ScriptAction) closeBlock() (err error) {
+	if a.keep == `` {
+		return nil
+	}
+
+	a.keep = ``
+
+	_, err = a.w.WriteString("fi\n")
+
+	return err
+}
+
+// Quote string for shell in single quotes
+func shellQuote(s string) string {
+	return `'` + strings.Replace(s, `'`, `'\''`, -1) + `'`
+}
+
+// Line breaks would end a comment
+func commentString(s string) string {
+	return strings.NewReplacer("\n", `\n`, "\r", `\r`).Replace(s)
+}
@@ -0,0 +1,182 @@
+package duplikaatti
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Groups with files in more directories are skipped when finding similar
+// directories, because every pair of the directories would be compared
+const SIMILAR_MAX_DIRS = 64
+
+// SimilarDirectories is a pair of directories which have files with the same
+// contents. Files in subdirectories are counted to the directory too.
+type SimilarDirectories struct {
+	Directories [2]string // Directories, sorted by path
+	Size        [2]uint64 // Size of files in each directory and its subdirectories
+	Shared      [2]uint64 // Size of files in each directory which have a copy in the other directory
+}
+
+// Percent returns how many percent of bytes of directory idx have a copy in the other directory
+func (s SimilarDirectories) Percent(idx int) float64 {
+	if s.Size[idx] == 0 {
+		return 0
+	}
+
+	return float64(s.Shared[idx]) * 100 / float64(s.Size[idx])
+}
+
+// Similar returns pairs of directories where at least minPercent of bytes of
+// either directory have a copy in the other directory. Bytes of files are
+// counted to every listed directory above them, so trees are compared as a
+// whole. A directory isn't paired with its own subdirectory, and a pair is
+// left out when directories above them form a similar pair too. Pairs are
+// ordered by shared bytes, largest first.
+func (t *DirectoryTree) Similar(groups []DuplicateGroup, minPercent float64) (similar []SimilarDirectories) {
+	type pair struct {
+		a, b string
+	}
+
+	// Size of files in each directory and its subdirectories
+	totals := map[string]uint64{}
+	for dir, d := range t.dirs {
+		t.ancestors(dir, func(a string) {
+			totals[a] += d.bytes
+		})
+	}
+
+	shared := map[pair]*SimilarDirectories{}
+
+	for _, g := range groups {
+		// Bytes of the group in each directory and in each directory above it
+		direct := map[string]bool{}
+		sizes := map[string]uint64{}
+
+		for _, f := range g.Files {
+			if f.IsArchiveMember() {
+				continue
+			}
+
+			dir := filepath.Dir(f.Path)
+			if _, ok := t.dirs[dir]; !ok {
+				continue
+			}
+
+			direct[dir] = true
+
+			t.ancestors(dir, func(a string) {
+				sizes[a] += f.Size
+			})
+		}
+
+		if len(direct) < 2 || len(direct) > SIMILAR_MAX_DIRS {
+			continue
+		}
+
+		dirs := make([]string, 0, len(sizes))
+		for dir := range sizes {
+			dirs = append(dirs, dir)
+		}
+		sort.Strings(dirs)
+
+		for i, a := range dirs {
+			for _, b := range dirs[i+1:] {
+				if isSubdirectory(a, b) {
+					continue
+				}
+
+				p := pair{a, b}
+
+				s, ok := shared[p]
+				if !ok {
+					s = &SimilarDirectories{
+						Directories: [2]string{a, b},
+						Size:        [2]uint64{totals[a], totals[b]},
+					}
+					shared[p] = s
+				}
+
+				s.Shared[0] += sizes[a]
+				s.Shared[1] += sizes[b]
+			}
+		}
+	}
+
+	isSimilar := func(s *SimilarDirectories) bool {
+		return s.Percent(0) >= minPercent || s.Percent(1) >= minPercent
+	}
+
+	for p, s := range shared {
+		if !isSimilar(s) {
+			continue
+		}
+
+		// Already shown as part of a pair of directories above them
+		inside := false
+
+		t.ancestors(p.a, func(a string) {
+			t.ancestors(p.b, func(b string) {
+				if inside || (a == p.a && b == p.b) {
+					return
+				}
+
+				outer := pair{a, b}
+				if b < a {
+					outer = pair{b, a}
+				}
+
+				if o, ok := shared[outer]; ok && isSimilar(o) {
+					inside = true
+				}
+			})
+		})
+
+		if inside {
+			continue
+		}
+
+		similar = append(similar, *s)
+	}
+
+	sort.Slice(similar, func(i, j int) bool {
+		a, b := similar[i], similar[j]
+
+		if a.Shared[0]+a.Shared[1] != b.Shared[0]+b.Shared[1] {
+			return a.Shared[0]+a.Shared[1] > b.Shared[0]+b.Shared[1]
+		}
+
+		if a.Directories[0] != b.Directories[0] {
+			return a.Directories[0] < b.Directories[0]
+		}
+
+		return a.Directories[1] < b.Directories[1]
+	})
+
+	return similar
+}
+
+// Call fn for dir and each listed directory above it
+func (t *DirectoryTree) ancestors(dir string, fn func(dir string)) {
+	for {
+		if _, ok := t.dirs[dir]; !ok {
+			return
+		}
+
+		fn(dir)
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+
+		dir = parent
+	}
+}
+
+// Is dir a subdirectory of parent
+func isSubdirectory(parent string, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+
+	return err == nil && rel != `..` && !strings.HasPrefix(rel, `..`+string(filepath.Separator))
+}
@@ -0,0 +1,135 @@
+package duplikaatti
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name used for files which aren't under any of the given roots and for files without an extension
+const SUMMARY_OTHER = `(other)`
+
+// SpaceUsage is amount of files and bytes which can be reclaimed
+type SpaceUsage struct {
+	Name  string
+	Files uint64
+	Bytes uint64
+}
+
+// SpaceSummary is reclaimable space of duplicates, that is the files which
+// would be removed, grouped in different ways. Lists are ordered by bytes,
+// largest first.
+type SpaceSummary struct {
+	Files       uint64
+	Bytes       uint64
+	Roots       []SpaceUsage // By scan root
+	Directories []SpaceUsage // By top-level directory under scan root
+	Owners      []SpaceUsage // By owner uid
+	Extensions  []SpaceUsage // By lower case file extension
+}
+
+// SummarizeSpace sums reclaimable space of given duplicate groups. Files are
+// matched to the longest of given roots.
+func SummarizeSpace(groups []DuplicateGroup, roots []string) (s SpaceSummary) {
+	byRoot := map[string]*SpaceUsage{}
+	byDir := map[string]*SpaceUsage{}
+	byOwner := map[string]*SpaceUsage{}
+	byExt := map[string]*SpaceUsage{}
+
+	for _, g := range groups {
+		for _, f := range g.Duplicates() {
+			s.Files++
+			s.Bytes += f.Size
+
+			root, dir := summaryRoot(roots, f.Path)
+
+			addUsage(byRoot, root, f.Size)
+			addUsage(byDir, dir, f.Size)
+			addUsage(byOwner, strconv.FormatUint(uint64(f.Owner), 10), f.Size)
+
+			ext := strings.ToLower(filepath.Ext(f.Path))
+			if ext == `` {
+				ext = SUMMARY_OTHER
+			}
+
+			addUsage(byExt, ext, f.Size)
+		}
+	}
+
+	s.Roots = sortedUsage(byRoot)
+	s.Directories = sortedUsage(byDir)
+	s.Owners = sortedUsage(byOwner)
+	s.Extensions = sortedUsage(byExt)
+
+	return s
+}
+
+// Find root of path and the top-level directory under it. Files directly in
+// the root are summed to the root.
+func summaryRoot(roots []string, path string) (root string, dir string) {
+	idx := RootIndex(roots, path)
+	if idx == -1 {
+		return SUMMARY_OTHER, SUMMARY_OTHER
+	}
+
+	root = filepath.Clean(roots[idx])
+	dir = root
+
+	rel, _ := filepath.Rel(root, path)
+	if idx := strings.IndexRune(rel, filepath.Separator); idx != -1 {
+		dir = filepath.Join(root, rel[:idx])
+	}
+
+	return root, dir
+}
+
+// RootIndex returns index of the longest of given roots which contains path
+// or is the path itself, or -1 when path isn't under any of them.
+func RootIndex(roots []string, path string) (index int) {
+	index = -1
+	longest := -1
+
+	for idx, r := range roots {
+		r = filepath.Clean(r)
+
+		rel, err := filepath.Rel(r, path)
+		if err != nil || rel == `..` || strings.HasPrefix(rel, `..`+string(filepath.Separator)) {
+			continue
+		}
+
+		if len(r) > longest {
+			index = idx
+			longest = len(r)
+		}
+	}
+
+	return index
+}
+
+func addUsage(m map[string]*SpaceUsage, name string, size uint64) {
+	u, ok := m[name]
+	if !ok {
+		u = &SpaceUsage{Name: name}
+		m[name] = u
+	}
+
+	u.Files++
+	u.Bytes += size
+}
+
+func sortedUsage(m map[string]*SpaceUsage) (list []SpaceUsage) {
+	for _, u := range m {
+		list = append(list, *u)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
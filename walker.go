@@ -0,0 +1,147 @@
+package duplikaatti
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Information about a file found in a directory
+type fileInformation struct {
+	Path       string // Path to file
+	Size       uint64 // File size
+	Identifier uint64 // Identifier (inode of the file or link target)
+	Device     uint64 // Device of the file or link target
+	Mode       os.FileMode
+	Depth      uint32 // Depth under scanned directory, set by Scanner
+}
+
+// File filter signature, returns false for files which are skipped
+type fileFilterFunc func(info fileInformation) bool
+
+// Contents of a listed directory
+type dirListing struct {
+	Dir    string
+	Files  []fileInformation
+	Dirs   []string
+	Errors []error
+}
+
+// List files and directories of given directory. Files which don't pass the
+// filter are skipped.
+func listDirectory(dir string, filter fileFilterFunc) (l dirListing) {
+	l.Dir = dir
+
+	d, err := os.Open(dir)
+	if err != nil {
+		l.Errors = append(l.Errors, err)
+		return l
+	}
+
+	entries, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		// Entries read before the error are still listed
+		l.Errors = append(l.Errors, err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			l.Dirs = append(l.Dirs, path)
+			continue
+		}
+
+		id, err := getFileID(path)
+		if err != nil {
+			// Dangling links are skipped silently like other unsupported files
+			if entry.Mode()&os.ModeSymlink == 0 {
+				l.Errors = append(l.Errors, err)
+			}
+
+			continue
+		}
+
+		fi := fileInformation{
+			Path:       path,
+			Size:       uint64(entry.Size()),
+			Identifier: id.INode,
+			Device:     id.Device,
+			Mode:       entry.Mode(),
+		}
+
+		if !filter(fi) {
+			continue
+		}
+
+		l.Files = append(l.Files, fi)
+	}
+
+	return l
+}
+
+// walkDirectory lists given directory recursively with workers in parallel
+// and calls handle for each listed directory. handle is called from the
+// calling goroutine only, so it doesn't need locking.
+//
+// The calling goroutine coordinates the walk: it owns the queue of directories
+// waiting to be listed and knows how many are being listed, so the walk ends
+// exactly when the queue is empty and no worker is busy. Workers are stopped by
+// closing their job channel and waited for before returning. When ctx is
+// cancelled no more directories are handed out and ctx.Err() is returned once
+// the directories being listed are done.
+func walkDirectory(ctx context.Context, root string, workerCount int, filter fileFilterFunc, handle func(dirListing)) error {
+	jobs := make(chan string)
+	listings := make(chan dirListing)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for dir := range jobs {
+				listings <- listDirectory(dir, filter)
+			}
+		}()
+	}
+
+	queue := []string{root}
+	busy := 0
+	done := ctx.Done()
+
+	for busy > 0 || (len(queue) > 0 && ctx.Err() == nil) {
+		// Only hand out directories when there are some and walk isn't cancelled
+		var send chan string
+		var next string
+
+		if len(queue) > 0 && ctx.Err() == nil {
+			send = jobs
+			next = queue[len(queue)-1]
+		}
+
+		select {
+		case <-done:
+			// Loop condition stops handing out directories
+			done = nil
+
+		case send <- next:
+			queue = queue[:len(queue)-1]
+			busy++
+
+		case l := <-listings:
+			busy--
+			queue = append(queue, l.Dirs...)
+			handle(l)
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return ctx.Err()
+}
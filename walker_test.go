@@ -0,0 +1,136 @@
+package duplikaatti
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create directory tree of given width and depth under a temporary directory.
+// Each directory has one file with content unique to the directory and one
+// file with content shared by all directories. Returns the root and the
+// amount of directories.
+func makeTree(t *testing.T, width int, depth int) (root string, dirs int) {
+	t.Helper()
+
+	root, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fill func(dir string, level int)
+	fill = func(dir string, level int) {
+		dirs++
+
+		err := ioutil.WriteFile(filepath.Join(dir, `unique`), []byte(dir), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, `shared`), []byte(`shared content`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if level == depth {
+			return
+		}
+
+		for i := 0; i < width; i++ {
+			sub := filepath.Join(dir, fmt.Sprintf(`d%v`, i))
+
+			err = os.Mkdir(sub, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fill(sub, level+1)
+		}
+	}
+
+	fill(root, 0)
+
+	return root, dirs
+}
+
+func TestWalkDirectory(t *testing.T) {
+	root, dirs := makeTree(t, 3, 3)
+	defer os.RemoveAll(root)
+
+	seen := map[string]bool{}
+	files := 0
+
+	err := walkDirectory(context.Background(), root, 4, getFilterFunc(false), func(l dirListing) {
+		if seen[l.Dir] {
+			t.Errorf(`%v listed twice`, l.Dir)
+		}
+
+		seen[l.Dir] = true
+		files += len(l.Files)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != dirs {
+		t.Fatalf(`got %v directories, expected %v`, len(seen), dirs)
+	}
+
+	if files != dirs*2 {
+		t.Fatalf(`got %v files, expected %v`, files, dirs*2)
+	}
+}
+
+func TestWalkDirectoryCancel(t *testing.T) {
+	root, dirs := makeTree(t, 4, 3)
+	defer os.RemoveAll(root)
+
+	const cancelAfter = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listed := 0
+
+	err := walkDirectory(ctx, root, 4, getFilterFunc(false), func(l dirListing) {
+		listed++
+
+		if listed == cancelAfter {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf(`got error %v, expected %v`, err, context.Canceled)
+	}
+
+	// Directories already handed out to workers are still handled
+	if listed < cancelAfter || listed > cancelAfter+4 {
+		t.Fatalf(`got %v directories listed after cancelling at %v`, listed, cancelAfter)
+	}
+
+	if listed >= dirs {
+		t.Fatalf(`whole tree of %v directories was listed`, dirs)
+	}
+}
+
+func TestScanDirectoryCancelled(t *testing.T) {
+	root, _ := makeTree(t, 2, 2)
+	defer os.RemoveAll(root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := NewScanner(ScannerOptions{Workers: 2})
+
+	err := s.ScanDirectory(ctx, root, Source{})
+	if err != context.Canceled {
+		t.Fatalf(`got error %v, expected %v`, err, context.Canceled)
+	}
+
+	if len(s.Files()) != 0 {
+		t.Fatalf(`got %v files from cancelled scan`, len(s.Files()))
+	}
+}
@@ -1,18 +1,30 @@
-package main
+package duplikaatti
 
 import (
-	"fmt"
-	"sync"
-	"os"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"io"
-	"time"
 	"log"
+	"sync"
+	"syscall"
+	"time"
 )
 
-type hasherWorkerResult struct {
-	Hash string
-	Info fileInfo
+// Size and checksums of a file, files with the same key may be duplicates
+type candidateKey struct {
+	Size  uint64
+	First Checksum
+	Last  Checksum
+}
+
+// File which is still a duplicate candidate
+type candidate struct {
+	Entry   fileEntry
+	Key     candidateKey
+	Hash    Checksum  // Checksum of the whole file
+	ModTime time.Time // Set when file is hashed
+	Owner   uint32    // Set when file is hashed
 }
 
 type ReadOperationType uint8
@@ -23,130 +35,209 @@ const (
 	READ_WHOLE                   = iota + 1
 )
 
-type hasherWorker struct {
-	Errors         chan error
-	Jobs           chan fileInfo
-	Results        chan hasherWorkerResult
-	Wg             *sync.WaitGroup
-	readSize       int64
-	readType       ReadOperationType
-	ticker         *time.Ticker
-	totalStartTime *time.Time
-	startTime      *time.Time
+// Progress stage of read operation
+func readOperationStage(rt ReadOperationType) string {
+	switch rt {
+	case READ_FIRST:
+		return STAGE_FIRST_BYTES
+	case READ_LAST:
+		return STAGE_LAST_BYTES
+	default:
+		return STAGE_HASH
+	}
 }
 
-func NewBytesWorker(t *time.Ticker, st *time.Time, workerCount int, readSize int64, rt ReadOperationType) hasherWorker {
-	now := time.Now()
+// hasherWorker reads files sent to Jobs and sends them with their checksums to
+// Results. Results and Errors are closed once Jobs is closed and all workers
+// have finished. When ctx is cancelled the rest of the jobs are discarded.
+type hasherWorker struct {
+	Errors   chan error
+	Jobs     chan candidate
+	Results  chan candidate
+	Wg       *sync.WaitGroup // Running workers
+	readSize int64
+	readType ReadOperationType
+	stage    string
+	paths    *pathTable
+	archives *archiveCache
+	progress *Progress
+	logger   *log.Logger
+}
 
+func newBytesWorker(ctx context.Context, p *Progress, logger *log.Logger, paths *pathTable, archives *archiveCache, workerCount int, readSize int64, rt ReadOperationType) hasherWorker {
 	w := hasherWorker{
-		Jobs:           make(chan fileInfo, workerCount*2),
-		Results:        make(chan hasherWorkerResult, 100),
-		Errors:         make(chan error),
-		Wg:             &sync.WaitGroup{},
-		readSize:       readSize,
-		readType:       rt,
-		ticker:         t,
-		totalStartTime: st,
-		startTime:      &now,
+		Jobs:     make(chan candidate, workerCount*2),
+		Results:  make(chan candidate, 100),
+		Errors:   make(chan error),
+		Wg:       &sync.WaitGroup{},
+		readSize: readSize,
+		readType: rt,
+		stage:    readOperationStage(rt),
+		paths:    paths,
+		archives: archives,
+		progress: p,
+		logger:   loggerOrDiscard(logger),
 	}
 
 	for i := 0; i < workerCount; i++ {
-		log.Printf(`Starting worker..`)
-		go w.worker()
+		w.logger.Printf(`Starting worker..`)
+		w.Wg.Add(1)
+		go w.worker(ctx)
 	}
 
+	go func() {
+		w.Wg.Wait()
+		close(w.Results)
+		close(w.Errors)
+	}()
+
 	return w
 }
 
-func (w *hasherWorker) worker() {
+func (w *hasherWorker) worker(ctx context.Context) {
+	defer w.Wg.Done()
+
 	buf := make([]byte, w.readSize)
 
-	for job := range w.Jobs {
-		f, err := os.Open(job.Path)
+	for c := range w.Jobs {
+		if ctx.Err() != nil {
+			continue
+		}
+
+		// Each job gives either a result or an error
+		res, err := w.hash(c, buf)
+		w.progress.Add(w.stage, 1, 0)
+
 		if err != nil {
 			w.Errors <- err
-			w.Wg.Done()
 			continue
 		}
 
-		h := sha256.New()
+		w.Results <- res
+	}
 
-		if w.readType == READ_LAST {
-			f.Seek(-w.readSize, io.SeekEnd)
+	w.logger.Printf(`Stopping worker..`)
+}
+
+// Read file of a candidate and set checksum of current read operation
+func (w *hasherWorker) hash(c candidate, buf []byte) (res candidate, err error) {
+	f, err := openEntry(w.paths, w.archives, c.Entry)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	// Small files are read whole already when reading first bytes
+	whole := w.readType == READ_WHOLE || (w.readType == READ_FIRST && c.Entry.Size <= uint64(w.readSize))
+
+	if whole {
+		// Needed for selecting which file is kept
+		c.ModTime = f.ModTime
+		c.Owner = f.Owner
+	}
+
+	w.progress.SetCurrent(w.stage, f.Path)
+
+	if w.readType == READ_LAST && c.Entry.Size > uint64(w.readSize) {
+		err = f.skip(int64(c.Entry.Size) - w.readSize)
+		if err != nil {
+			return c, err
 		}
+	}
 
-		for {
+	sum, err := checksum(f, buf, whole, func(n int) {
+		w.progress.Add(w.stage, 0, uint64(n))
+	})
+	if err != nil {
+		return c, err
+	}
 
-			rb, err := f.Read(buf)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
+	switch w.readType {
+	case READ_FIRST:
+		c.Key.First = sum
 
-				w.Errors <- err
-				w.Wg.Done()
-				continue
-			}
+		if whole {
+			c.Hash = sum
+		}
+	case READ_LAST:
+		c.Key.Last = sum
+	default:
+		c.Hash = sum
+	}
 
-			if rb < 0 {
-				w.Errors <- fmt.Errorf(`rb was < 0`)
-			}
+	return c, nil
+}
 
-			if int64(rb) > w.readSize {
-				w.Errors <- fmt.Errorf(`rb was > read size`)
-			}
+// Calculate checksum of one buffer or whole reader when whole is set. Read
+// bytes are reported to progress.
+func checksum(r io.Reader, buf []byte, whole bool, progress func(n int)) (sum Checksum, err error) {
+	h := sha256.New()
 
-			select {
-			case <-w.ticker.C:
+	for {
+		n, err := readFull(r, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			progress(n)
+		}
 
-				readType := ``
+		if err == io.EOF {
+			break
+		}
 
-				switch w.readType {
-				case READ_FIRST:
-					readType = `First bytes`
-				case READ_LAST:
-					readType = `Last bytes`
-				case READ_WHOLE:
-					readType = `Hashing`
-				}
+		if err != nil {
+			return sum, err
+		}
 
-				log.Printf(`[%v] %v %v %v`, time.Since(*w.totalStartTime).Truncate(time.Second), time.Since(*w.startTime).Truncate(time.Second), readType, job.Path)
-			default:
+		if !whole {
+			break
+		}
+	}
 
-			}
+	copy(sum[:], h.Sum(nil))
 
-			// Calculate checksum further
-			wb, err := h.Write(buf[0:rb])
-			if err != nil {
-				w.Errors <- err
-				w.Wg.Done()
-				continue
-			}
+	return sum, nil
+}
 
-			if wb < 0 {
-				w.Errors <- fmt.Errorf(`wb was < 0`)
-			}
+// Times a transient read error is retried
+const READ_RETRIES = 5
 
-			if int64(wb) > w.readSize {
-				w.Errors <- fmt.Errorf(`wb was > read size`)
-			}
+// Is error temporary so that read can be retried
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
 
-			if w.readType == READ_FIRST {
-				break
-			}
+// Read from r, interrupted and temporarily unavailable reads are retried
+func readRetry(r io.Reader, buf []byte) (n int, err error) {
+	for i := 0; ; i++ {
+		n, err = r.Read(buf)
 
+		if err == nil || !isTransient(err) {
+			return n, err
 		}
 
-		f.Close()
+		if n > 0 {
+			return n, nil
+		}
 
-		w.Results <- hasherWorkerResult{
-			Hash: fmt.Sprintf(`%x`, h.Sum(nil)),
-			Info: job,
+		if i >= READ_RETRIES {
+			return n, err
 		}
 
-		w.Wg.Done()
+		time.Sleep(time.Millisecond << uint(i))
 	}
+}
+
+// Fill buf from r. Returns io.EOF (with n possibly > 0) when the end was
+// reached before buf was filled.
+func readFull(r io.Reader, buf []byte) (n int, err error) {
+	for n < len(buf) {
+		rb, err := readRetry(r, buf[n:])
+		n += rb
 
-	log.Printf(`Stopping worker..`)
+		if err != nil {
+			return n, err
+		}
+	}
 
+	return n, nil
 }
@@ -0,0 +1,271 @@
+package duplikaatti
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Result of a single Read call of faultReader
+type readStep struct {
+	data string
+	err  error
+}
+
+// faultReader returns given steps one Read call at a time and io.EOF after them
+type faultReader struct {
+	steps []readStep
+	reads int
+}
+
+func (r *faultReader) Read(p []byte) (n int, err error) {
+	r.reads++
+
+	if len(r.steps) == 0 {
+		return 0, io.EOF
+	}
+
+	s := &r.steps[0]
+	n = copy(p, s.data)
+	s.data = s.data[n:]
+
+	if s.data != `` {
+		// Rest of the data is returned by the next call
+		return n, nil
+	}
+
+	err = s.err
+	r.steps = r.steps[1:]
+
+	return n, err
+}
+
+// Reader which always fails with given error
+func failing(err error, times int) (steps []readStep) {
+	for i := 0; i < times; i++ {
+		steps = append(steps, readStep{err: err})
+	}
+
+	return steps
+}
+
+var errHard = errors.New(`hard error`)
+
+func TestReadRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []readStep
+		data  string
+		err   error
+		reads int
+	}{
+		{`plain read`, []readStep{{data: `abc`}}, `abc`, nil, 1},
+		{`EINTR is retried`, append(failing(syscall.EINTR, 2), readStep{data: `abc`}), `abc`, nil, 3},
+		{`EAGAIN is retried`, append(failing(syscall.EAGAIN, 3), readStep{data: `abc`}), `abc`, nil, 4},
+		{`wrapped EINTR is retried`, []readStep{{err: &os.PathError{Op: `read`, Path: `f`, Err: syscall.EINTR}}, {data: `abc`}}, `abc`, nil, 2},
+		{`data with EINTR is returned`, []readStep{{data: `ab`, err: syscall.EINTR}}, `ab`, nil, 1},
+		{`EAGAIN gives up`, failing(syscall.EAGAIN, READ_RETRIES+5), ``, syscall.EAGAIN, READ_RETRIES + 1},
+		{`hard error is not retried`, failing(errHard, 3), ``, errHard, 1},
+		{`EOF is not retried`, nil, ``, io.EOF, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &faultReader{steps: tt.steps}
+			buf := make([]byte, 16)
+
+			n, err := readRetry(r, buf)
+
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf(`got error %v, expected %v`, err, tt.err)
+			}
+
+			if string(buf[:n]) != tt.data {
+				t.Fatalf(`got %#v, expected %#v`, string(buf[:n]), tt.data)
+			}
+
+			if r.reads != tt.reads {
+				t.Fatalf(`got %v reads, expected %v`, r.reads, tt.reads)
+			}
+		})
+	}
+}
+
+func TestReadFull(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []readStep
+		data  string
+		err   error
+	}{
+		{`short reads`, []readStep{{data: `ab`}, {data: `c`}, {data: `def`}}, `abcdef`, nil},
+		{`transient errors between short reads`, []readStep{{data: `ab`}, {err: syscall.EINTR}, {data: `cd`, err: syscall.EAGAIN}, {err: syscall.EAGAIN}, {data: `efgh`}}, `abcdef`, nil},
+		{`end before buffer is full`, []readStep{{data: `ab`}, {data: `c`}}, `abc`, io.EOF},
+		{`data with EOF`, []readStep{{data: `abc`, err: io.EOF}}, `abc`, io.EOF},
+		{`hard error after short read`, []readStep{{data: `ab`}, {err: errHard}}, `ab`, errHard},
+		{`transient error gives up`, append([]readStep{{data: `ab`}}, failing(syscall.EINTR, READ_RETRIES+1)...), `ab`, syscall.EINTR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, 6)
+
+			n, err := readFull(&faultReader{steps: tt.steps}, buf)
+
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf(`got error %v, expected %v`, err, tt.err)
+			}
+
+			if string(buf[:n]) != tt.data {
+				t.Fatalf(`got %#v, expected %#v`, string(buf[:n]), tt.data)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []readStep
+		whole bool
+		data  string // Expected summed data
+		err   error
+	}{
+		{`whole with short reads`, []readStep{{data: `abc`}, {data: `d`}, {data: `efghij`}}, true, `abcdefghij`, nil},
+		{`whole with transient errors`, []readStep{{data: `ab`, err: syscall.EINTR}, {err: syscall.EAGAIN}, {data: `cdefghij`, err: syscall.EINTR}}, true, `abcdefghij`, nil},
+		{`first buffer only`, []readStep{{data: `ab`}, {err: syscall.EINTR}, {data: `cdefghij`}}, false, `abcd`, nil},
+		{`first buffer of short file`, []readStep{{data: `ab`}}, false, `ab`, nil},
+		{`empty`, nil, true, ``, nil},
+		{`hard error`, []readStep{{data: `abcdef`}, {err: errHard}}, true, ``, errHard},
+		{`transient error gives up`, append([]readStep{{data: `abcdef`}}, failing(syscall.EAGAIN, READ_RETRIES+1)...), true, ``, syscall.EAGAIN},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var read int
+
+			sum, err := checksum(&faultReader{steps: tt.steps}, make([]byte, 4), tt.whole, func(n int) {
+				read += n
+			})
+
+			if !errors.Is(err, tt.err) || (err == nil) != (tt.err == nil) {
+				t.Fatalf(`got error %v, expected %v`, err, tt.err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if expected := Checksum(sha256.Sum256([]byte(tt.data))); sum != expected {
+				t.Fatalf(`got checksum %v, expected checksum of %#v`, sum, tt.data)
+			}
+
+			if read != len(tt.data) {
+				t.Fatalf(`got %v bytes reported, expected %v`, read, len(tt.data))
+			}
+		})
+	}
+}
+
+func TestHasherWorkerResults(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `duplikaatti`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := newPathTable()
+
+	var jobs []candidate
+	expected := map[string]bool{} // Path and whether it should fail
+
+	for i, content := range []string{`a`, `abcd`, `abcdefghij`, `abcdefghijklmnopq`} {
+		path := filepath.Join(dir, string(rune('a'+i)))
+
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		jobs = append(jobs, candidate{Entry: paths.entry(File{Path: path, Size: uint64(len(content))})})
+		expected[path] = false
+	}
+
+	// Missing file and file which changed size after scanning
+	missing := filepath.Join(dir, `missing`)
+	jobs = append(jobs, candidate{Entry: paths.entry(File{Path: missing, Size: 3})})
+	expected[missing] = true
+
+	changed := filepath.Join(dir, `changed`)
+
+	err = ioutil.WriteFile(changed, []byte(`abc`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs = append(jobs, candidate{Entry: paths.entry(File{Path: changed, Size: 100})})
+	expected[changed] = true
+
+	for _, rt := range []ReadOperationType{READ_FIRST, READ_LAST, READ_WHOLE} {
+		archives := newArchiveCache(``)
+		w := newBytesWorker(context.Background(), nil, nil, paths, archives, 3, 4, rt)
+
+		go func() {
+			for _, c := range jobs {
+				w.Jobs <- c
+			}
+
+			close(w.Jobs)
+		}()
+
+		got := map[string]int{}
+		results, errs := w.Results, w.Errors
+
+		for results != nil || errs != nil {
+			select {
+			case c, ok := <-results:
+				if !ok {
+					results = nil
+					continue
+				}
+
+				path := paths.join(c.Entry.Dir, c.Entry.Name)
+				got[path]++
+
+				if expected[path] {
+					t.Errorf(`read type %v: %v should have failed`, rt, path)
+				}
+			case err, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+
+				var perr *os.PathError
+				if !errors.As(err, &perr) {
+					t.Fatalf(`read type %v: error without path: %v`, rt, err)
+				}
+
+				got[perr.Path]++
+
+				if !expected[perr.Path] {
+					t.Errorf(`read type %v: %v failed: %v`, rt, perr.Path, err)
+				}
+			}
+		}
+
+		archives.Close()
+
+		for path := range expected {
+			if got[path] != 1 {
+				t.Errorf(`read type %v: %v gave %v results or errors, expected one`, rt, path, got[path])
+			}
+		}
+	}
+}